@@ -11,8 +11,15 @@ import (
 )
 
 type cacheData[T any] struct {
-	locker sync.RWMutex
-	data   map[string]*cData[T]
+	locker     sync.RWMutex
+	data       map[string]*cData[T]
+	evictor    Evictor       // 淘汰策略，nil表示不限制容量
+	maxEntries int           // 最大数量
+	maxCost    int64         // 最大开销
+	cost       func(T) int64 // 开销计算，nil时每条数据开销为1
+	used       int64         // 当前开销
+	accesses   accessBuffer  // 读取时记录的访问，持有写锁时交给淘汰策略
+	counters   counters      // 统计
 }
 
 func (cd *cacheData[T]) len() int {
@@ -24,32 +31,177 @@ func (cd *cacheData[T]) clear() {
 	cd.locker.Lock()
 	defer cd.locker.Unlock()
 	cd.data = make(map[string]*cData[T])
+	cd.used = 0
+	cd.accesses.drain(nil)
+	if cd.evictor != nil {
+		cd.evictor.Reset()
+	}
 }
-func (cd *cacheData[T]) store(key string, value T, expire time.Time) {
+
+// store saves the value, returns the entries evicted to make room for it,
+// or the value itself if it is not admitted by the evictor
+func (cd *cacheData[T]) store(key string, value T, expire time.Time) map[string]T {
 	cd.locker.Lock()
 	defer cd.locker.Unlock()
-	cd.data[key] = &cData[T]{data: value, expire: expire}
+	cd.counters.stores.Add(1)
+	if !cd.bounded() {
+		cd.data[key] = &cData[T]{data: value, expire: expire, stored: time.Now()}
+		return nil
+	}
+	evicted := make(map[string]T)
+	c := cd.costOf(value)
+	cd.accesses.drain(cd.evictor.Access)
+	cd.evictor.Access(key)
+	if v, ok := cd.data[key]; ok {
+		cd.used += c - v.cost
+		cd.data[key] = &cData[T]{data: value, expire: expire, stored: time.Now(), cost: c}
+		cd.trim(evicted)
+		return evicted
+	}
+	if cd.maxCost > 0 && c > cd.maxCost {
+		cd.counters.evictions.Add(1)
+		evicted[key] = value
+		return evicted
+	}
+	for cd.over(1, c) {
+		victim, ok := cd.evictor.Victim()
+		if !ok {
+			break
+		}
+		if !cd.evictor.Admit(key, victim) {
+			cd.counters.evictions.Add(1)
+			evicted[key] = value
+			return evicted
+		}
+		cd.evict(victim, evicted)
+	}
+	cd.data[key] = &cData[T]{data: value, expire: expire, stored: time.Now(), cost: c}
+	cd.used += c
+	cd.evictor.Add(key)
+	return evicted
+}
+
+// bounded reports whether the capacity is limited
+func (cd *cacheData[T]) bounded() bool {
+	return cd.evictor != nil && (cd.maxEntries > 0 || cd.maxCost > 0)
+}
+func (cd *cacheData[T]) costOf(value T) int64 {
+	if cd.cost == nil {
+		return 1
+	}
+	return cd.cost(value)
+}
+
+// over reports whether adding n entries with the cost c exceeds the capacity
+func (cd *cacheData[T]) over(n int, c int64) bool {
+	return (cd.maxEntries > 0 && len(cd.data)+n > cd.maxEntries) ||
+		(cd.maxCost > 0 && cd.used+c > cd.maxCost)
+}
+
+// trim evicts entries until the capacity is satisfied
+func (cd *cacheData[T]) trim(evicted map[string]T) {
+	for cd.over(0, 0) {
+		victim, ok := cd.evictor.Victim()
+		if !ok {
+			return
+		}
+		cd.evict(victim, evicted)
+	}
+}
+func (cd *cacheData[T]) evict(key string, evicted map[string]T) {
+	if v, ok := cd.data[key]; ok {
+		cd.counters.evictions.Add(1)
+		evicted[key] = v.data
+		cd.used -= v.cost
+		delete(cd.data, key)
+	}
+	cd.evictor.Remove(key)
+}
+
+// remove deletes the entry, the caller must hold the lock
+func (cd *cacheData[T]) remove(key string) {
+	if cd.bounded() {
+		if v, ok := cd.data[key]; ok {
+			cd.used -= v.cost
+		}
+		cd.evictor.Remove(key)
+	}
+	delete(cd.data, key)
+}
+
+// bound applies the capacity settings, returns the entries evicted to satisfy them
+func (cd *cacheData[T]) bound(set func()) map[string]T {
+	cd.locker.Lock()
+	defer cd.locker.Unlock()
+	set()
+	if cd.evictor == nil {
+		cd.evictor = NewLRUEvictor()
+	}
+	if !cd.bounded() {
+		return nil
+	}
+	cd.evictor.Reset()
+	cd.accesses.drain(nil)
+	cd.used = 0
+	for k, v := range cd.data {
+		v.cost = cd.costOf(v.data)
+		cd.used += v.cost
+		cd.evictor.Add(k)
+	}
+	evicted := make(map[string]T)
+	cd.trim(evicted)
+	return evicted
 }
 func (cd *cacheData[T]) load(key string) (T, bool) {
+	v, _, ok := cd.lookup(key)
+	return v, ok
+}
+
+// lookup returns the value and the time it was stored
+func (cd *cacheData[T]) lookup(key string) (T, time.Time, bool) {
+	v, ok := cd.entry(key)
+	return v.data, v.stored, ok
+}
+
+// entry returns a copy of the entry
+func (cd *cacheData[T]) entry(key string) (cData[T], bool) {
+	v, ok, bounded := cd.read(key)
+	// 淘汰策略需要记录访问，先缓存起来，缓存满时如果写锁空闲则交给淘汰策略，否则等下次写入时处理
+	if bounded && cd.accesses.add(key) && cd.locker.TryLock() {
+		if cd.bounded() {
+			cd.accesses.drain(cd.evictor.Access)
+		}
+		cd.locker.Unlock()
+	}
+	return v, ok
+}
+
+// read returns a copy of the entry with the read lock held, and whether the capacity is limited
+func (cd *cacheData[T]) read(key string) (cData[T], bool, bool) {
 	cd.locker.RLock()
 	defer cd.locker.RUnlock()
 	v, ok := cd.data[key]
-	if !ok {
-		var x T
-		return x, false
-	}
-	if v.expire.Before(time.Now()) {
-		var x T
-		return x, false
+	if !ok || v.expire.Before(time.Now()) {
+		cd.counters.misses.Add(1)
+		return cData[T]{}, false, cd.bounded()
 	}
-	return v.data, true
+	cd.counters.hits.Add(1)
+	return *v, true, cd.bounded()
 }
-func (cd *cacheData[T]) delete(key ...string) {
+
+// delete removes the entries, returns the removed ones
+func (cd *cacheData[T]) delete(key ...string) map[string]T {
 	cd.locker.Lock()
 	defer cd.locker.Unlock()
+	deleted := make(map[string]T, len(key))
 	for _, k := range key {
-		delete(cd.data, k)
+		if v, ok := cd.data[k]; ok {
+			deleted[k] = v.data
+			cd.remove(k)
+		}
 	}
+	cd.counters.deletions.Add(uint64(len(deleted)))
+	return deleted
 }
 func (cd *cacheData[T]) clone() map[string]*cData[T] {
 	cd.locker.RLock()
@@ -99,15 +251,18 @@ func (cd *cacheData[T]) clearExpired() map[string]T {
 	for k, v := range cd.data {
 		if now.After(v.expire) {
 			expired[k] = v.data
-			delete(cd.data, k)
+			cd.remove(k)
 		}
 	}
+	cd.counters.expirations.Add(uint64(len(expired)))
 	return expired
 }
 
 type cData[T any] struct {
 	expire time.Time
+	stored time.Time
 	data   T
+	cost   int64
 }
 
 // AnyCache 泛型结构缓存
@@ -116,6 +271,10 @@ type AnyCache[T any] struct {
 	cacheCleanup    *time.Ticker
 	cleanupInterval time.Duration
 	cacheExpire     time.Duration
+	expireFunc      func(map[string]T)
+	loads           *loadGroup[T]
+	snap            snapshot
+	hooks           hooks[T]
 	closed          bool
 	closeCtx        context.Context
 	closeFunc       context.CancelFunc
@@ -128,7 +287,7 @@ type AnyCache[T any] struct {
 //
 // Parameters:
 //   - expire: The duration for which cache entries should be considered valid.
-//   - expireFunc: An optional function to be executed when a cache entry expires.
+//   - expireFunc: An optional function to be executed when a cache entry expires or is evicted, see SetCapacity.
 //     The function will receive a map of expired entries, where the key is the entry key and the value is the entry data.
 //
 // Return:
@@ -137,6 +296,8 @@ func NewAnyCacheWithExpireFunc[T any](expire time.Duration, expireFunc func(map[
 	ctx, cancel := context.WithCancel(context.Background())
 	x := &AnyCache[T]{
 		cacheExpire:  expire,
+		expireFunc:   expireFunc,
+		loads:        newLoadGroup[T](),
 		cache:        &cacheData[T]{data: make(map[string]*cData[T])},
 		cacheCleanup: time.NewTicker(time.Minute),
 		closeCtx:     ctx,
@@ -149,12 +310,8 @@ func NewAnyCacheWithExpireFunc[T any](expire time.Duration, expireFunc func(map[
 			case <-x.closeCtx.Done():
 				return
 			case <-x.cacheCleanup.C:
-				expired := x.cache.clearExpired()
-				if len(expired) > 0 && expireFunc != nil {
-					loopfunc.GoFunc(func(params ...any) {
-						expireFunc(expired)
-					}, "expire func", logger.NewConsoleWriter())
-				}
+				x.expired(x.cache.clearExpired())
+				x.loads.clearExpired()
 			}
 		}
 	}, "any cache", logger.NewConsoleWriter())
@@ -184,6 +341,62 @@ func NewAnyCache[T any](expire time.Duration) *AnyCache[T] {
 	return NewAnyCacheWithExpireFunc[T](expire, nil)
 }
 
+// expired hands the expired or evicted entries to the expire func
+func (ac *AnyCache[T]) expired(data map[string]T) {
+	if len(data) == 0 {
+		return
+	}
+	ac.hooks.expired(data)
+	if ac.expireFunc == nil {
+		return
+	}
+	loopfunc.GoFunc(func(params ...any) {
+		ac.expireFunc(data)
+	}, "expire func", logger.NewConsoleWriter())
+}
+
+// store saves the entry, then reports the evicted entries and calls the store hook
+func (ac *AnyCache[T]) store(key string, value T, expire time.Time) {
+	evicted := ac.cache.store(key, value, expire)
+	ac.expired(evicted)
+	ac.hooks.stored(key, value, evicted)
+}
+
+// SetCapacity bounds the number of entries in the cache, entries are evicted by the evictor when the cache is full.
+// Evicted entries are handed to the expire func, see NewAnyCacheWithExpireFunc.
+// It is recommended to set the capacity before the cache is used, existing entries are evicted at once if they do not fit.
+// Loads only take the read lock, the accesses are buffered and handed to the evictor in batches, on the next store
+// or when the buffer is full, so the eviction order reflects the recent loads approximately.
+//
+// Parameters:
+//   - maxEntries: The maximum number of entries, 0 means unlimited.
+//   - evictor: The eviction policy, such as NewLRUEvictor, NewLFUEvictor or NewTinyLFUEvictor, nil means LRU.
+//
+// Example:
+//
+//	cache := NewAnyCacheWithExpireFunc[*Device](time.Hour, func(m map[string]*Device) { ... })
+//	cache.SetCapacity(10000, NewTinyLFUEvictor(10000))
+func (ac *AnyCache[T]) SetCapacity(maxEntries int, evictor Evictor) {
+	ac.expired(ac.cache.bound(func() {
+		ac.cache.maxEntries = maxEntries
+		ac.cache.evictor = evictor
+	}))
+}
+
+// SetMaxCost bounds the total cost of the entries in the cache, such as the memory size,
+// entries are evicted in the same way as SetCapacity.
+// An entry whose cost exceeds maxCost is never stored.
+//
+// Parameters:
+//   - maxCost: The maximum total cost, 0 means unlimited.
+//   - cost: The function returns the cost of a value, nil means each entry costs 1.
+func (ac *AnyCache[T]) SetMaxCost(maxCost int64, cost func(value T) int64) {
+	ac.expired(ac.cache.bound(func() {
+		ac.cache.maxCost = maxCost
+		ac.cache.cost = cost
+	}))
+}
+
 // SetCleanUp sets the cleanup period for the cache. The cleanup period should not be less than 1 second.
 // If the cleanup period is less than 1 second, it will be automatically set to 1 second.
 //
@@ -197,13 +410,16 @@ func (ac *AnyCache[T]) SetCleanUp(cleanup time.Duration) {
 }
 
 // Close closes this cache. If the cache needs to be used again, it should be reinitialized using the NewAnyCache method.
+// If the snapshot is enabled, a last snapshot is saved before the cache is cleared, see EnableSnapshot.
 // This method stops the cleanup goroutine, sends a signal to close the channel, clears the cache, and sets the cache pointer to nil.
 func (ac *AnyCache[T]) Close() {
 	ac.closeOnce.Do(func() {
+		ac.saveSnapshot()
 		ac.closed = true
 		ac.cacheCleanup.Stop()
 		ac.closeFunc()
 		ac.cache.clear()
+		ac.loads.clear()
 	})
 }
 
@@ -216,6 +432,7 @@ func (ac *AnyCache[T]) Clear() {
 		return
 	}
 	ac.cache.clear()
+	ac.loads.clear()
 }
 
 // Len returns the number of entries in the cache.
@@ -274,7 +491,7 @@ func (ac *AnyCache[T]) StoreWithExpire(key string, value T, expire time.Duration
 		return fmt.Errorf("cache is closed")
 	}
 	if !ac.cache.isExpire(key) {
-		ac.cache.store(key, value, time.Now().Add(expire))
+		ac.store(key, value, time.Now().Add(expire))
 	}
 	return nil
 }
@@ -301,6 +518,20 @@ func (ac *AnyCache[T]) Load(key string) (T, bool) {
 	return v, true
 }
 
+// LoadWithTTL returns the value and the remaining lifetime of the entry, see TTLLoader.
+func (ac *AnyCache[T]) LoadWithTTL(key string) (T, time.Duration, bool) {
+	if ac.closed {
+		var zero T
+		return zero, 0, false
+	}
+	v, ok := ac.cache.entry(key)
+	if !ok {
+		var zero T
+		return zero, 0, false
+	}
+	return v.data, max(time.Until(v.expire), 0), true
+}
+
 // LoadOrStore reads or sets a cache entry.
 //
 // When the key exists:
@@ -324,7 +555,7 @@ func (ac *AnyCache[T]) LoadOrStore(key string, value T) (T, bool) {
 	}
 	v, ok := ac.cache.load(key)
 	if !ok {
-		ac.cache.store(key, value, time.Now().Add(ac.cacheExpire))
+		ac.store(key, value, time.Now().Add(ac.cacheExpire))
 		return value, false
 	}
 	return v, true
@@ -342,14 +573,14 @@ func (ac *AnyCache[T]) Delete(key string) {
 	if ac.closed {
 		return
 	}
-	ac.cache.delete(key)
+	ac.hooks.deleted(ac.cache.delete(key))
 }
 
 func (ac *AnyCache[T]) DeleteMore(keys ...string) {
 	if ac.closed {
 		return
 	}
-	ac.cache.delete(keys...)
+	ac.hooks.deleted(ac.cache.delete(keys...))
 }
 
 // ForEach iterates over all the entries in the cache and applies the provided function to each entry.
@@ -1,7 +1,11 @@
 package cache
 
 import (
+	"errors"
+	"path/filepath"
 	"strconv"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -24,3 +28,395 @@ func BenchmarkCache(t *testing.B) {
 	// 	a.Load(strconv.Itoa(i + 1))
 	// }
 }
+
+func TestAnyCacheEviction(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		evictor Evictor
+		kept    []string
+		evicted []string
+	}{
+		// a 被访问过，b 最久未使用
+		{name: "lru", evictor: NewLRUEvictor(), kept: []string{"a", "c", "d"}, evicted: []string{"b"}},
+		// a 访问3次，c 访问2次，b 访问1次且更早
+		{name: "lfu", evictor: NewLFUEvictor(), kept: []string{"a", "c", "d"}, evicted: []string{"b"}},
+		// d 只出现过一次，不足以替换被访问过的 b
+		{name: "tinylfu", evictor: NewTinyLFUEvictor(16), kept: []string{"a", "b", "c"}, evicted: []string{"d"}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var evicted []string
+			locker := sync.Mutex{}
+			a := NewAnyCacheWithExpireFunc[int](time.Hour, func(m map[string]int) {
+				locker.Lock()
+				defer locker.Unlock()
+				for k := range m {
+					evicted = append(evicted, k)
+				}
+			})
+			defer a.Close()
+			a.SetCapacity(3, tt.evictor)
+			a.Store("a", 1)
+			a.Store("b", 2)
+			a.Store("c", 3)
+			a.Load("a")
+			a.Load("a")
+			a.Load("b")
+			a.Load("c")
+			a.Load("c")
+			a.Load("a")
+			a.Store("d", 4)
+			if a.Len() != 3 {
+				t.Fatalf("expect 3 entries, got %d", a.Len())
+			}
+			for _, k := range tt.kept {
+				if _, ok := a.Load(k); !ok {
+					t.Fatalf("expect %s kept", k)
+				}
+			}
+			time.Sleep(time.Millisecond * 50)
+			locker.Lock()
+			defer locker.Unlock()
+			if len(evicted) != len(tt.evicted) || evicted[0] != tt.evicted[0] {
+				t.Fatalf("expect %v evicted, got %v", tt.evicted, evicted)
+			}
+		})
+	}
+}
+
+func TestAnyCacheBufferedAccess(t *testing.T) {
+	a := NewAnyCache[int](time.Hour)
+	defer a.Close()
+	a.SetCapacity(3, NewLRUEvictor())
+	a.Store("a", 1)
+	a.Store("b", 2)
+	a.Store("c", 3)
+	// 并发读取只持有读锁，超出访问缓存容量的访问被丢弃，但不影响淘汰顺序的近似
+	wg := sync.WaitGroup{}
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range accessBufferSize * 4 {
+				a.Load("a")
+				a.Load("c")
+			}
+		}()
+	}
+	wg.Wait()
+	a.Store("d", 4)
+	if _, ok := a.Load("b"); ok {
+		t.Fatal("expect b evicted")
+	}
+	for _, k := range []string{"a", "c", "d"} {
+		if _, ok := a.Load(k); !ok {
+			t.Fatalf("expect %s kept", k)
+		}
+	}
+}
+
+func TestAnyCacheMaxCost(t *testing.T) {
+	a := NewAnyCache[string](time.Hour)
+	defer a.Close()
+	a.SetMaxCost(10, func(v string) int64 { return int64(len(v)) })
+	a.Store("a", "12345")
+	a.Store("b", "1234")
+	a.Store("c", "123")
+	if _, ok := a.Load("a"); ok || a.Len() != 2 {
+		t.Fatalf("expect a evicted, got %d entries", a.Len())
+	}
+	a.Store("d", "12345678901")
+	if _, ok := a.Load("d"); ok {
+		t.Fatal("expect entry larger than max cost not stored")
+	}
+	a.Delete("b")
+	a.Store("e", "1234567")
+	if a.Len() != 2 {
+		t.Fatalf("expect 2 entries, got %d", a.Len())
+	}
+}
+
+func TestShardedCache(t *testing.T) {
+	var c Cache[int] = NewShardedCache[int](time.Hour, 10)
+	defer c.Close()
+	if n := c.(*ShardedCache[int]).Shards(); n != 16 {
+		t.Fatalf("expect 16 shards, got %d", n)
+	}
+	for i := 0; i < 100; i++ {
+		c.Store(strconv.Itoa(i), i)
+	}
+	c.StoreWithExpire("expired", 1, -time.Second)
+	if v, ok := c.Load("42"); !ok || v != 42 {
+		t.Fatalf("expect 42, got %d %v", v, ok)
+	}
+	if _, ok := c.Load("expired"); ok {
+		t.Fatal("expect expired entry not loaded")
+	}
+	if v, ok := c.LoadOrStore("42", 0); !ok || v != 42 {
+		t.Fatalf("expect 42 loaded, got %d %v", v, ok)
+	}
+	c.Delete("42")
+	sum := 0
+	c.ForEach(func(key string, value int) bool {
+		sum += value
+		return true
+	})
+	if sum != 4950-42 {
+		t.Fatalf("unexpected sum %d", sum)
+	}
+}
+
+func benchmarkParallelStore(b *testing.B, c Cache[*bbb]) {
+	defer c.Close()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			i++
+			key := strconv.Itoa(i % 100000)
+			c.Store(key, &bbb{BBB: "string"})
+			c.Load(key)
+		}
+	})
+}
+
+func BenchmarkAnyCacheParallel(b *testing.B) {
+	benchmarkParallelStore(b, NewAnyCache[*bbb](time.Hour))
+}
+
+func BenchmarkShardedCacheParallel(b *testing.B) {
+	benchmarkParallelStore(b, NewShardedCache[*bbb](time.Hour, 64))
+}
+
+// localInvalidator 模拟广播，直接通知其他副本
+type localInvalidator struct {
+	peers []*TieredCache[int]
+}
+
+func (l *localInvalidator) Publish(keys ...string) error {
+	for _, p := range l.peers {
+		p.Invalidate(keys...)
+	}
+	return nil
+}
+
+func TestTieredCache(t *testing.T) {
+	remote := NewAnyCache[int](time.Hour)
+	defer remote.Close()
+	a := NewTieredCache[int](time.Minute, remote)
+	b := NewTieredCache[int](time.Minute, remote)
+	defer a.Close()
+	defer b.Close()
+	a.SetInvalidator(&localInvalidator{peers: []*TieredCache[int]{b}})
+
+	a.Store("k", 1)
+	if v, ok := b.Load("k"); !ok || v != 1 {
+		t.Fatalf("expect 1 from remote, got %d %v", v, ok)
+	}
+	if _, ok := b.Local().Load("k"); !ok {
+		t.Fatal("expect local tier populated")
+	}
+	a.Store("k", 2)
+	if v, _ := b.Load("k"); v != 2 {
+		t.Fatalf("expect stale local copy dropped, got %d", v)
+	}
+	a.Delete("k")
+	if _, ok := b.Load("k"); ok {
+		t.Fatal("expect deleted")
+	}
+}
+
+// slowRemote 在读取远端后、回填本地前执行 onLoad，用于模拟并发的失效
+type slowRemote struct {
+	*AnyCache[int]
+	onLoad func()
+}
+
+func (r *slowRemote) LoadWithTTL(key string) (int, time.Duration, bool) {
+	v, ttl, ok := r.AnyCache.LoadWithTTL(key)
+	if r.onLoad != nil {
+		r.onLoad()
+	}
+	return v, ttl, ok
+}
+
+func TestTieredCacheExpire(t *testing.T) {
+	remote := &slowRemote{AnyCache: NewAnyCache[int](time.Hour)}
+	defer remote.Close()
+	a := NewTieredCache[int](time.Minute, remote)
+	defer a.Close()
+
+	// 0 表示远端不过期，本地按本地过期时间保存
+	a.StoreWithExpire("forever", 1, 0)
+	if _, ok := a.Local().Load("forever"); !ok {
+		t.Fatal("expect the local copy kept for expire 0")
+	}
+	// 负数删除两级缓存
+	a.StoreWithExpire("forever", 1, -1)
+	if _, ok := a.Local().Load("forever"); ok {
+		t.Fatal("expect the local copy removed for a negative expire")
+	}
+
+	// 本地副本不会比远端数据存活更久
+	remote.StoreWithExpire("short", 1, time.Millisecond*100)
+	if v, ok := a.Load("short"); !ok || v != 1 {
+		t.Fatalf("expect 1 from remote, got %d %v", v, ok)
+	}
+	time.Sleep(time.Millisecond * 150)
+	if v, ok := a.Load("short"); ok {
+		t.Fatalf("expect expired with the remote entry, got %d", v)
+	}
+
+	// 读取远端期间收到失效通知，回填的旧值被丢弃
+	remote.Store("k", 1)
+	remote.onLoad = func() {
+		remote.onLoad = nil
+		remote.Store("k", 2)
+		a.Invalidate("k")
+	}
+	if v, _ := a.Load("k"); v != 1 {
+		t.Fatalf("expect the value read before the invalidation, got %d", v)
+	}
+	if v, ok := a.Load("k"); !ok || v != 2 {
+		t.Fatalf("expect the stale copy dropped, got %d %v", v, ok)
+	}
+}
+
+func TestGetOrLoad(t *testing.T) {
+	a := NewAnyCache[int](time.Hour)
+	defer a.Close()
+	var calls atomic.Int32
+	loader := func(key string) (int, error) {
+		calls.Add(1)
+		time.Sleep(time.Millisecond * 50)
+		return int(calls.Load()), nil
+	}
+	wg := sync.WaitGroup{}
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if v, err := a.GetOrLoad("k", loader); err != nil || v != 1 {
+				t.Errorf("expect 1, got %d %v", v, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if calls.Load() != 1 {
+		t.Fatalf("expect 1 loader call, got %d", calls.Load())
+	}
+
+	// 软过期后返回旧值并后台刷新
+	a.SetRefreshAfter(time.Millisecond * 10)
+	time.Sleep(time.Millisecond * 20)
+	if v, _ := a.GetOrLoad("k", loader); v != 1 {
+		t.Fatalf("expect stale value 1, got %d", v)
+	}
+	time.Sleep(time.Millisecond * 100)
+	if v, _ := a.Load("k"); v != 2 {
+		t.Fatalf("expect refreshed value 2, got %d", v)
+	}
+
+	// 错误缓存
+	a.SetNegativeTTL(time.Millisecond * 100)
+	errLoader := func(key string) (int, error) {
+		calls.Add(1)
+		return 0, errors.New("db down")
+	}
+	calls.Store(0)
+	for range 3 {
+		if _, err := a.GetOrLoad("bad", errLoader); err == nil {
+			t.Fatal("expect loader error")
+		}
+	}
+	if calls.Load() != 1 {
+		t.Fatalf("expect loader error cached, got %d calls", calls.Load())
+	}
+	time.Sleep(time.Millisecond * 150)
+	a.GetOrLoad("bad", errLoader)
+	if calls.Load() != 2 {
+		t.Fatalf("expect loader called after negative ttl, got %d calls", calls.Load())
+	}
+
+	// 后台刷新失败后，在错误缓存时间内不再刷新
+	calls.Store(0)
+	for range 5 {
+		if v, err := a.GetOrLoad("k", errLoader); err != nil || v != 2 {
+			t.Fatalf("expect stale value 2, got %d %v", v, err)
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	if calls.Load() != 1 {
+		t.Fatalf("expect 1 refresh after a failure, got %d calls", calls.Load())
+	}
+	time.Sleep(time.Millisecond * 100)
+	a.GetOrLoad("k", errLoader)
+	time.Sleep(time.Millisecond * 20)
+	if calls.Load() != 2 {
+		t.Fatalf("expect refreshed again after the back-off, got %d calls", calls.Load())
+	}
+}
+
+func TestAnyCacheSnapshot(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.snap")
+	a := NewAnyCache[*bbb](time.Hour)
+	if err := a.EnableSnapshot(path, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	a.Store("a", &bbb{BBB: "1"})
+	a.StoreWithExpire("b", &bbb{BBB: "2"}, time.Millisecond*50)
+	a.Close()
+
+	time.Sleep(time.Millisecond * 100)
+	b := NewAnyCache[*bbb](time.Hour)
+	defer b.Close()
+	if err := b.EnableSnapshot(path, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := b.Load("a"); !ok || v.BBB != "1" {
+		t.Fatalf("expect a restored, got %v %v", v, ok)
+	}
+	if _, ok := b.Load("b"); ok {
+		t.Fatal("expect expired entry b not restored")
+	}
+
+	r := NewRing[int](3)
+	r.StoreMany(1, 2, 3, 4)
+	rpath := filepath.Join(t.TempDir(), "ring.snap")
+	if err := r.SaveFile(rpath); err != nil {
+		t.Fatal(err)
+	}
+	r2 := NewRing[int](3)
+	r2.Store(9)
+	if err := r2.LoadFile(rpath); err != nil {
+		t.Fatal(err)
+	}
+	if s := r2.Slice(); len(s) != 3 || s[0] != 2 || s[2] != 4 {
+		t.Fatalf("unexpected ring restored: %v", s)
+	}
+}
+
+func TestAnyCacheStats(t *testing.T) {
+	a := NewAnyCache[int](time.Hour)
+	defer a.Close()
+	stored, deleted := 0, 0
+	a.SetHooks(Hooks[int]{
+		OnStore:  func(key string, value int) { stored++ },
+		OnDelete: func(key string, value int) { deleted++ },
+	})
+	a.SetCapacity(2, nil)
+	a.Store("a", 1)
+	a.Store("b", 2)
+	a.Store("c", 3)
+	a.Load("a")
+	a.Load("c")
+	a.Delete("c")
+	a.Delete("x")
+	s := a.Stats()
+	want := Stats{Hits: 1, Misses: 1, Stores: 3, Deletions: 1, Evictions: 1, Size: 1}
+	if s != want {
+		t.Fatalf("expect %+v, got %+v", want, s)
+	}
+	if s.HitRatio() != 0.5 || stored != 3 || deleted != 1 {
+		t.Fatalf("unexpected ratio %v, hooks %d %d", s.HitRatio(), stored, deleted)
+	}
+}
@@ -0,0 +1,305 @@
+package cache
+
+import (
+	"container/heap"
+	"container/list"
+	"hash/fnv"
+	"sync"
+)
+
+// accessBufferSize 访问缓存的容量，缓存满后新的访问被丢弃，直到缓存被处理
+const accessBufferSize = 64
+
+// accessBuffer 缓存读取时的访问记录，避免每次读取都持有缓存的写锁
+type accessBuffer struct {
+	locker sync.Mutex
+	keys   []string
+}
+
+// add records an access, reports whether the buffer is full
+func (b *accessBuffer) add(key string) bool {
+	b.locker.Lock()
+	defer b.locker.Unlock()
+	if len(b.keys) < accessBufferSize {
+		b.keys = append(b.keys, key)
+	}
+	return len(b.keys) >= accessBufferSize
+}
+
+// drain hands the recorded accesses to f in order and empties the buffer, nil f discards them
+func (b *accessBuffer) drain(f func(string)) {
+	b.locker.Lock()
+	keys := b.keys
+	if len(keys) > 0 {
+		b.keys = make([]string, 0, accessBufferSize)
+	}
+	b.locker.Unlock()
+	if f == nil {
+		return
+	}
+	for _, k := range keys {
+		f(k)
+	}
+}
+
+// Evictor is the eviction policy of a size bounded AnyCache, see AnyCache.SetCapacity.
+// The methods are called with the cache locked, implementations do not need to be safe for concurrent use.
+type Evictor interface {
+	// Access is called on each lookup and store of the key, whether the key is cached or not
+	Access(key string)
+	// Add is called when a new key is stored
+	Add(key string)
+	// Remove is called when the key is deleted, expired or evicted
+	Remove(key string)
+	// Victim returns the key to be evicted next, false if there is no key
+	Victim() (string, bool)
+	// Admit reports whether the new key is worth caching at the cost of evicting the victim
+	Admit(key, victim string) bool
+	// Reset removes all the keys
+	Reset()
+}
+
+// lruEvictor 淘汰最久未使用的数据
+type lruEvictor struct {
+	ll    *list.List
+	items map[string]*list.Element
+}
+
+// NewLRUEvictor returns an Evictor that evicts the least recently used key
+func NewLRUEvictor() Evictor {
+	return &lruEvictor{
+		ll:    list.New(),
+		items: make(map[string]*list.Element),
+	}
+}
+
+func (e *lruEvictor) Access(key string) {
+	if el, ok := e.items[key]; ok {
+		e.ll.MoveToFront(el)
+	}
+}
+
+func (e *lruEvictor) Add(key string) {
+	if el, ok := e.items[key]; ok {
+		e.ll.MoveToFront(el)
+		return
+	}
+	e.items[key] = e.ll.PushFront(key)
+}
+
+func (e *lruEvictor) Remove(key string) {
+	if el, ok := e.items[key]; ok {
+		e.ll.Remove(el)
+		delete(e.items, key)
+	}
+}
+
+func (e *lruEvictor) Victim() (string, bool) {
+	el := e.ll.Back()
+	if el == nil {
+		return "", false
+	}
+	return el.Value.(string), true
+}
+
+func (e *lruEvictor) Admit(string, string) bool { return true }
+
+func (e *lruEvictor) Reset() {
+	e.ll.Init()
+	e.items = make(map[string]*list.Element)
+}
+
+// lfuItem 访问计数，同计数时按最后访问顺序淘汰
+type lfuItem struct {
+	key   string
+	freq  uint64
+	tick  uint64
+	index int
+}
+
+type lfuHeap []*lfuItem
+
+func (h lfuHeap) Len() int { return len(h) }
+func (h lfuHeap) Less(i, j int) bool {
+	if h[i].freq != h[j].freq {
+		return h[i].freq < h[j].freq
+	}
+	return h[i].tick < h[j].tick
+}
+func (h lfuHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+func (h *lfuHeap) Push(x any) {
+	item := x.(*lfuItem)
+	item.index = len(*h)
+	*h = append(*h, item)
+}
+func (h *lfuHeap) Pop() any {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	old[n-1] = nil
+	*h = old[:n-1]
+	return item
+}
+
+// lfuEvictor 淘汰访问次数最少的数据
+type lfuEvictor struct {
+	heap  lfuHeap
+	items map[string]*lfuItem
+	tick  uint64
+}
+
+// NewLFUEvictor returns an Evictor that evicts the least frequently used key,
+// the least recently used one is evicted among the keys with the same frequency.
+func NewLFUEvictor() Evictor {
+	return &lfuEvictor{
+		items: make(map[string]*lfuItem),
+	}
+}
+
+func (e *lfuEvictor) Access(key string) {
+	if item, ok := e.items[key]; ok {
+		e.tick++
+		item.freq++
+		item.tick = e.tick
+		heap.Fix(&e.heap, item.index)
+	}
+}
+
+func (e *lfuEvictor) Add(key string) {
+	if _, ok := e.items[key]; ok {
+		e.Access(key)
+		return
+	}
+	e.tick++
+	item := &lfuItem{key: key, freq: 1, tick: e.tick}
+	e.items[key] = item
+	heap.Push(&e.heap, item)
+}
+
+func (e *lfuEvictor) Remove(key string) {
+	if item, ok := e.items[key]; ok {
+		heap.Remove(&e.heap, item.index)
+		delete(e.items, key)
+	}
+}
+
+func (e *lfuEvictor) Victim() (string, bool) {
+	if len(e.heap) == 0 {
+		return "", false
+	}
+	return e.heap[0].key, true
+}
+
+func (e *lfuEvictor) Admit(string, string) bool { return true }
+
+func (e *lfuEvictor) Reset() {
+	e.heap = nil
+	e.items = make(map[string]*lfuItem)
+}
+
+// sketch count-min sketch，4位计数上限，定期减半以淡化历史访问
+type sketch struct {
+	rows      [4][]uint8
+	mask      uint32
+	additions int
+	resetAt   int
+}
+
+func newSketch(size int) *sketch {
+	w := 16
+	for w < size {
+		w <<= 1
+	}
+	s := &sketch{
+		mask:    uint32(w - 1),
+		resetAt: w * 10,
+	}
+	for i := range s.rows {
+		s.rows[i] = make([]uint8, w)
+	}
+	return s
+}
+
+func (s *sketch) indexes(key string) [4]uint32 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	sum := h.Sum64()
+	h1, h2 := uint32(sum), uint32(sum>>32)
+	var idx [4]uint32
+	for i := range idx {
+		idx[i] = (h1 + uint32(i)*h2) & s.mask
+	}
+	return idx
+}
+
+func (s *sketch) increment(key string) {
+	for i, n := range s.indexes(key) {
+		if s.rows[i][n] < 15 {
+			s.rows[i][n]++
+		}
+	}
+	s.additions++
+	if s.additions >= s.resetAt {
+		for i := range s.rows {
+			for n := range s.rows[i] {
+				s.rows[i][n] >>= 1
+			}
+		}
+		s.additions /= 2
+	}
+}
+
+func (s *sketch) estimate(key string) uint8 {
+	var m uint8 = 15
+	for i, n := range s.indexes(key) {
+		m = min(m, s.rows[i][n])
+	}
+	return m
+}
+
+func (s *sketch) reset() {
+	for i := range s.rows {
+		clear(s.rows[i])
+	}
+	s.additions = 0
+}
+
+// tinyLFUEvictor LRU淘汰，按访问频率决定新数据是否准入
+type tinyLFUEvictor struct {
+	lruEvictor
+	sketch *sketch
+}
+
+// NewTinyLFUEvictor returns an Evictor that evicts the least recently used key, but only admits a new key
+// when it has been used at least as often as the victim, so that one-off keys do not flush the hot ones.
+// The access frequency is estimated by a count-min sketch sized for about size keys.
+func NewTinyLFUEvictor(size int) Evictor {
+	if size <= 0 {
+		size = 1024
+	}
+	return &tinyLFUEvictor{
+		lruEvictor: lruEvictor{
+			ll:    list.New(),
+			items: make(map[string]*list.Element),
+		},
+		sketch: newSketch(size),
+	}
+}
+
+func (e *tinyLFUEvictor) Access(key string) {
+	e.sketch.increment(key)
+	e.lruEvictor.Access(key)
+}
+
+func (e *tinyLFUEvictor) Admit(key, victim string) bool {
+	return e.sketch.estimate(key) >= e.sketch.estimate(victim)
+}
+
+func (e *tinyLFUEvictor) Reset() {
+	e.lruEvictor.Reset()
+	e.sketch.reset()
+}
@@ -0,0 +1,234 @@
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/xyzj/toolbox/logger"
+	"github.com/xyzj/toolbox/loopfunc"
+)
+
+// ErrLoaderPanic is returned to the callers waiting for a loader that panicked
+var ErrLoaderPanic = errors.New("cache loader panic")
+
+// loadCall 正在执行的加载，等待者共享结果
+type loadCall[T any] struct {
+	done chan struct{}
+	val  T
+	err  error
+}
+
+// negative 缓存的加载错误
+type negative struct {
+	err    error
+	expire time.Time
+}
+
+// loadGroup 合并同一key的并发加载，并短暂缓存加载错误
+type loadGroup[T any] struct {
+	locker      sync.Mutex
+	calls       map[string]*loadCall[T]
+	errs        map[string]*negative
+	retry       map[string]time.Time // 刷新失败后，下次后台刷新的时间
+	refresh     atomic.Int64         // 软过期时间
+	negativeTTL atomic.Int64         // 错误缓存时间
+}
+
+func newLoadGroup[T any]() *loadGroup[T] {
+	return &loadGroup[T]{
+		calls: make(map[string]*loadCall[T]),
+		errs:  make(map[string]*negative),
+		retry: make(map[string]time.Time),
+	}
+}
+
+// begin returns the call in flight of the key, or registers a new one, leader is true for the new one
+func (g *loadGroup[T]) begin(key string) (*loadCall[T], bool) {
+	g.locker.Lock()
+	defer g.locker.Unlock()
+	if c, ok := g.calls[key]; ok {
+		return c, false
+	}
+	c := &loadCall[T]{done: make(chan struct{}), err: ErrLoaderPanic}
+	g.calls[key] = c
+	return c, true
+}
+
+func (g *loadGroup[T]) end(key string, c *loadCall[T]) {
+	g.locker.Lock()
+	delete(g.calls, key)
+	g.locker.Unlock()
+	close(c.done)
+}
+
+// do runs fn once for all the concurrent callers of the same key
+func (g *loadGroup[T]) do(key string, fn func() (T, error)) (T, error) {
+	c, leader := g.begin(key)
+	if !leader {
+		<-c.done
+		return c.val, c.err
+	}
+	defer g.end(key, c)
+	c.val, c.err = fn()
+	return c.val, c.err
+}
+
+// background runs fn in a new goroutine unless a call of the key is in flight
+func (g *loadGroup[T]) background(key string, fn func() (T, error)) {
+	c, leader := g.begin(key)
+	if !leader {
+		return
+	}
+	loopfunc.GoFunc(func(params ...any) {
+		defer g.end(key, c)
+		c.val, c.err = fn()
+	}, "cache refresh "+key, logger.NewConsoleWriter())
+}
+
+// fail caches the loader error of the key
+func (g *loadGroup[T]) fail(key string, err error) {
+	ttl := time.Duration(g.negativeTTL.Load())
+	if ttl <= 0 {
+		return
+	}
+	g.locker.Lock()
+	g.errs[key] = &negative{err: err, expire: time.Now().Add(ttl)}
+	g.locker.Unlock()
+}
+
+// failed returns the cached loader error of the key
+func (g *loadGroup[T]) failed(key string) (error, bool) {
+	g.locker.Lock()
+	defer g.locker.Unlock()
+	n, ok := g.errs[key]
+	if !ok {
+		return nil, false
+	}
+	if time.Now().After(n.expire) {
+		delete(g.errs, key)
+		return nil, false
+	}
+	return n.err, true
+}
+
+// backoff delays the next background refresh of the key after a failed refresh,
+// by the negative ttl if it is set, otherwise by the refresh interval
+func (g *loadGroup[T]) backoff(key string) {
+	d := time.Duration(g.negativeTTL.Load())
+	if d <= 0 {
+		d = time.Duration(g.refresh.Load())
+	}
+	g.locker.Lock()
+	g.retry[key] = time.Now().Add(d)
+	g.locker.Unlock()
+}
+
+// throttled reports whether the background refresh of the key is delayed by a failed refresh
+func (g *loadGroup[T]) throttled(key string) bool {
+	g.locker.Lock()
+	defer g.locker.Unlock()
+	next, ok := g.retry[key]
+	if !ok {
+		return false
+	}
+	if time.Now().After(next) {
+		delete(g.retry, key)
+		return false
+	}
+	return true
+}
+
+func (g *loadGroup[T]) clearExpired() {
+	g.locker.Lock()
+	defer g.locker.Unlock()
+	now := time.Now()
+	for k, n := range g.errs {
+		if now.After(n.expire) {
+			delete(g.errs, k)
+		}
+	}
+	for k, next := range g.retry {
+		if now.After(next) {
+			delete(g.retry, k)
+		}
+	}
+}
+
+func (g *loadGroup[T]) clear() {
+	g.locker.Lock()
+	g.errs = make(map[string]*negative)
+	g.retry = make(map[string]time.Time)
+	g.locker.Unlock()
+}
+
+// SetRefreshAfter enables stale-while-revalidate for GetOrLoad: an entry older than refresh is still returned,
+// while the loader is called in the background to refresh it. 0 disables the background refresh.
+// After a failed refresh, the key is not refreshed again for the negative ttl, or for refresh if it is not set.
+// The refresh should be shorter than the cache expiration, after which the entry is loaded synchronously.
+func (ac *AnyCache[T]) SetRefreshAfter(refresh time.Duration) {
+	ac.loads.refresh.Store(int64(refresh))
+}
+
+// SetNegativeTTL enables negative caching for GetOrLoad: a loader error is returned directly for the duration
+// without calling the loader again, to protect the database behind the loader. 0 disables negative caching.
+func (ac *AnyCache[T]) SetNegativeTTL(ttl time.Duration) {
+	ac.loads.negativeTTL.Store(int64(ttl))
+}
+
+// GetOrLoad returns the cached value of the key, or calls the loader and stores its result on a miss.
+// Concurrent misses of the same key are collapsed into one loader call, the other callers wait for its result.
+// See SetRefreshAfter for serving stale values and SetNegativeTTL for caching loader errors.
+//
+// Parameters:
+//   - key: The unique identifier for the cache entry.
+//   - loader: The function loads the value of the key, such as a database query.
+//
+// Return:
+//   - The cached or loaded value.
+//   - The loader error, or an error if the cache is closed.
+//
+// Example:
+//
+//	user, err := users.GetOrLoad(id, func(key string) (*User, error) {
+//		return queryUser(key)
+//	})
+func (ac *AnyCache[T]) GetOrLoad(key string, loader func(key string) (T, error)) (T, error) {
+	if ac.closed {
+		var zero T
+		return zero, fmt.Errorf("cache is closed")
+	}
+	load := func() (T, error) {
+		v, err := loader(key)
+		if err != nil {
+			return v, err
+		}
+		ac.store(key, v, time.Now().Add(ac.cacheExpire))
+		return v, nil
+	}
+	if v, stored, ok := ac.cache.lookup(key); ok {
+		if refresh := time.Duration(ac.loads.refresh.Load()); refresh > 0 && time.Since(stored) > refresh && !ac.loads.throttled(key) {
+			ac.loads.background(key, func() (T, error) {
+				v, err := load()
+				if err != nil {
+					ac.loads.backoff(key)
+				}
+				return v, err
+			})
+		}
+		return v, nil
+	}
+	if err, ok := ac.loads.failed(key); ok {
+		var zero T
+		return zero, err
+	}
+	return ac.loads.do(key, func() (T, error) {
+		v, err := load()
+		if err != nil {
+			ac.loads.fail(key, err)
+		}
+		return v, err
+	})
+}
@@ -0,0 +1,236 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/xyzj/toolbox/logger"
+	"github.com/xyzj/toolbox/loopfunc"
+)
+
+const defaultShards = 32
+
+// ShardedCache 分片缓存，按key的哈希值将数据分散到多个独立加锁的分片，适用于高并发读写
+type ShardedCache[T any] struct {
+	shards       []*cacheData[T]
+	mask         uint32
+	cacheCleanup *time.Ticker
+	cacheExpire  time.Duration
+	expireFunc   func(map[string]T)
+	hooks        hooks[T]
+	closed       bool
+	closeCtx     context.Context
+	closeFunc    context.CancelFunc
+	closeOnce    sync.Once
+}
+
+// NewShardedCacheWithExpireFunc initializes a new sharded cache with a specified expiration time and an optional expiration function.
+// It works like AnyCache, but the entries are spread over the shards by the hash of the key,
+// each shard has its own lock, so that concurrent writes of different keys rarely block each other.
+// When the cache is no longer needed, it should be closed using the Close() method.
+//
+// Parameters:
+//   - expire: The duration for which cache entries should be considered valid.
+//   - shards: The number of shards, rounded up to a power of 2, 32 is used if it is not positive.
+//   - expireFunc: An optional function to be executed when cache entries expire, the same as NewAnyCacheWithExpireFunc.
+//
+// Return:
+// - A pointer to the newly created ShardedCache instance.
+func NewShardedCacheWithExpireFunc[T any](expire time.Duration, shards int, expireFunc func(map[string]T)) *ShardedCache[T] {
+	if shards <= 0 {
+		shards = defaultShards
+	}
+	n := 1
+	for n < shards {
+		n <<= 1
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	x := &ShardedCache[T]{
+		shards:       make([]*cacheData[T], n),
+		mask:         uint32(n - 1),
+		cacheExpire:  expire,
+		expireFunc:   expireFunc,
+		cacheCleanup: time.NewTicker(time.Minute),
+		closeCtx:     ctx,
+		closeFunc:    cancel,
+		closeOnce:    sync.Once{},
+	}
+	for i := range x.shards {
+		x.shards[i] = &cacheData[T]{data: make(map[string]*cData[T])}
+	}
+	go loopfunc.LoopFunc(func(params ...any) {
+		for {
+			select {
+			case <-x.closeCtx.Done():
+				return
+			case <-x.cacheCleanup.C:
+				expired := make(map[string]T)
+				for _, shard := range x.shards {
+					for k, v := range shard.clearExpired() {
+						expired[k] = v
+					}
+				}
+				x.expired(expired)
+			}
+		}
+	}, "sharded cache", logger.NewConsoleWriter())
+	return x
+}
+
+// NewShardedCache initializes a new sharded cache with a specified expiration time, see NewShardedCacheWithExpireFunc.
+//
+// Example:
+//
+//	cache := NewShardedCache[int](time.Minute*5, 64)
+//	defer cache.Close()
+//	cache.Store("key1", 100)
+func NewShardedCache[T any](expire time.Duration, shards int) *ShardedCache[T] {
+	return NewShardedCacheWithExpireFunc[T](expire, shards, nil)
+}
+
+// shard returns the shard of the key by fnv-1a hash, inlined to avoid allocations
+func (sc *ShardedCache[T]) shard(key string) *cacheData[T] {
+	h := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		h ^= uint32(key[i])
+		h *= 16777619
+	}
+	return sc.shards[h&sc.mask]
+}
+
+func (sc *ShardedCache[T]) expired(data map[string]T) {
+	if len(data) == 0 {
+		return
+	}
+	sc.hooks.expired(data)
+	if sc.expireFunc == nil {
+		return
+	}
+	loopfunc.GoFunc(func(params ...any) {
+		sc.expireFunc(data)
+	}, "expire func", logger.NewConsoleWriter())
+}
+
+// Shards returns the number of shards
+func (sc *ShardedCache[T]) Shards() int {
+	return len(sc.shards)
+}
+
+// SetCleanUp sets the cleanup period for the cache, the same as AnyCache.SetCleanUp.
+func (sc *ShardedCache[T]) SetCleanUp(cleanup time.Duration) {
+	if cleanup < time.Second {
+		cleanup = time.Second
+	}
+	sc.cacheCleanup.Reset(cleanup)
+}
+
+// Close closes this cache. If the cache needs to be used again, it should be reinitialized using the NewShardedCache method.
+func (sc *ShardedCache[T]) Close() {
+	sc.closeOnce.Do(func() {
+		sc.closed = true
+		sc.cacheCleanup.Stop()
+		sc.closeFunc()
+		for _, shard := range sc.shards {
+			shard.clear()
+		}
+	})
+}
+
+// Clear clears all the entries from the cache.
+// If the cache is already closed, this function does nothing.
+func (sc *ShardedCache[T]) Clear() {
+	if sc.closed {
+		return
+	}
+	for _, shard := range sc.shards {
+		shard.clear()
+	}
+}
+
+// Len returns the number of entries in the cache, including the expired entries not cleaned up yet.
+// If the cache is closed, it returns 0.
+func (sc *ShardedCache[T]) Len() int {
+	if sc.closed {
+		return 0
+	}
+	n := 0
+	for _, shard := range sc.shards {
+		n += shard.len()
+	}
+	return n
+}
+
+// Extension extends the expiration time of the specified cache entry by the cache's default expiration duration.
+func (sc *ShardedCache[T]) Extension(key string) {
+	sc.shard(key).expire(key, time.Now().Add(sc.cacheExpire))
+}
+
+// Store adds a cache entry with the specified key and value.
+// If the cache is already closed, it returns an error.
+func (sc *ShardedCache[T]) Store(key string, value T) error {
+	return sc.StoreWithExpire(key, value, sc.cacheExpire)
+}
+
+// StoreWithExpire adds a cache entry with the specified key, value, and expiration duration.
+// If the cache is already closed, it returns an error.
+func (sc *ShardedCache[T]) StoreWithExpire(key string, value T, expire time.Duration) error {
+	if sc.closed {
+		return fmt.Errorf("cache is closed")
+	}
+	shard := sc.shard(key)
+	if !shard.isExpire(key) {
+		sc.hooks.stored(key, value, shard.store(key, value, time.Now().Add(expire)))
+	}
+	return nil
+}
+
+// Load retrieves the value associated with the given key from the cache.
+// If the key is not found or the entry has expired, it returns the zero value of type T and false.
+func (sc *ShardedCache[T]) Load(key string) (T, bool) {
+	if sc.closed {
+		var zero T
+		return zero, false
+	}
+	return sc.shard(key).load(key)
+}
+
+// LoadOrStore reads or sets a cache entry, the same as AnyCache.LoadOrStore.
+func (sc *ShardedCache[T]) LoadOrStore(key string, value T) (T, bool) {
+	if sc.closed {
+		var zero T
+		return zero, false
+	}
+	shard := sc.shard(key)
+	if v, ok := shard.load(key); ok {
+		return v, true
+	}
+	sc.hooks.stored(key, value, shard.store(key, value, time.Now().Add(sc.cacheExpire)))
+	return value, false
+}
+
+// Delete removes a cache entry with the specified key.
+// If the cache is already closed, this function does nothing.
+func (sc *ShardedCache[T]) Delete(key string) {
+	if sc.closed {
+		return
+	}
+	sc.hooks.deleted(sc.shard(key).delete(key))
+}
+
+// ForEach iterates over all the entries in the cache shard by shard, excluding expired entries.
+// If the function returns false, the iteration will be stopped.
+// Only one shard is locked at a time, so the iteration is not a consistent snapshot of the whole cache.
+func (sc *ShardedCache[T]) ForEach(f func(key string, value T) bool) {
+	next := true
+	for _, shard := range sc.shards {
+		shard.foreach(func(key string, value T) bool {
+			next = f(key, value)
+			return next
+		})
+		if !next {
+			return
+		}
+	}
+}
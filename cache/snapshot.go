@@ -0,0 +1,221 @@
+package cache
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/xyzj/toolbox/json"
+	"github.com/xyzj/toolbox/logger"
+	"github.com/xyzj/toolbox/loopfunc"
+)
+
+// snapshotEntry 快照文件中的一条缓存
+type snapshotEntry[T any] struct {
+	Key    string    `json:"key"`
+	Expire time.Time `json:"expire"`
+	Value  T         `json:"value"`
+}
+
+// snapshot 定时快照配置
+type snapshot struct {
+	locker sync.Mutex
+	path   string
+	ticker *time.Ticker
+}
+
+// writeFile writes the file by a temporary file and an atomic rename, so that a crash never leaves a broken file
+func writeFile(path string, write func(w io.Writer) error) error {
+	fd, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(fd.Name())
+	bw := bufio.NewWriter(fd)
+	if err = write(bw); err == nil {
+		err = bw.Flush()
+	}
+	if err == nil {
+		err = fd.Sync()
+	}
+	if cerr := fd.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(fd.Name(), path)
+}
+
+// readFile reads the file, returns nil if the file does not exist
+func readFile(path string, read func(r io.Reader) error) error {
+	fd, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer fd.Close()
+	return read(bufio.NewReader(fd))
+}
+
+// entries copies the unexpired entries
+func (cd *cacheData[T]) entries() []*snapshotEntry[T] {
+	cd.locker.RLock()
+	defer cd.locker.RUnlock()
+	now := time.Now()
+	x := make([]*snapshotEntry[T], 0, len(cd.data))
+	for k, v := range cd.data {
+		if now.After(v.expire) {
+			continue
+		}
+		x = append(x, &snapshotEntry[T]{Key: k, Expire: v.expire, Value: v.data})
+	}
+	return x
+}
+
+// SaveTo writes the unexpired entries to w as json lines, with their expiration time.
+// The value type must be able to round-trip through json, see LoadFrom.
+func (ac *AnyCache[T]) SaveTo(w io.Writer) error {
+	for _, e := range ac.cache.entries() {
+		b, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		if _, err = w.Write(append(b, '\n')); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LoadFrom reads the entries written by SaveTo and stores them with their original expiration time,
+// the entries expired in the meantime are skipped. Existing entries with the same keys are overwritten.
+func (ac *AnyCache[T]) LoadFrom(r io.Reader) error {
+	if ac.closed {
+		return fmt.Errorf("cache is closed")
+	}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 64*1024*1024)
+	now := time.Now()
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		e := &snapshotEntry[T]{}
+		if err := json.Unmarshal(line, e); err != nil {
+			return err
+		}
+		if now.After(e.Expire) {
+			continue
+		}
+		ac.store(e.Key, e.Value, e.Expire)
+	}
+	return scanner.Err()
+}
+
+// SaveFile writes a snapshot of the cache to the file, see SaveTo.
+// The snapshot is written to a temporary file in the same directory first, then renamed to the file,
+// so the file always holds a complete snapshot.
+func (ac *AnyCache[T]) SaveFile(path string) error {
+	return writeFile(path, ac.SaveTo)
+}
+
+// LoadFile restores the cache from the snapshot file, see LoadFrom.
+// It returns nil if the file does not exist, so it can be called unconditionally on startup.
+func (ac *AnyCache[T]) LoadFile(path string) error {
+	return readFile(path, ac.LoadFrom)
+}
+
+// EnableSnapshot restores the cache from the snapshot file, then saves the cache to the file periodically
+// and once more when the cache is closed, so that the cache survives restarts.
+// Calling it again changes the file and the interval, the cache is not restored again.
+//
+// Parameters:
+//   - path: The snapshot file.
+//   - interval: The period of the snapshot, not less than 1 second.
+//
+// Return:
+//   - An error if the snapshot file exists but can not be restored, the periodic snapshot is enabled anyway.
+func (ac *AnyCache[T]) EnableSnapshot(path string, interval time.Duration) error {
+	if ac.closed {
+		return fmt.Errorf("cache is closed")
+	}
+	interval = max(interval, time.Second)
+	ac.snap.locker.Lock()
+	defer ac.snap.locker.Unlock()
+	ac.snap.path = path
+	if ac.snap.ticker != nil {
+		ac.snap.ticker.Reset(interval)
+		return nil
+	}
+	err := ac.LoadFile(path)
+	ac.snap.ticker = time.NewTicker(interval)
+	go loopfunc.LoopFunc(func(params ...any) {
+		defer ac.snap.ticker.Stop()
+		for {
+			select {
+			case <-ac.closeCtx.Done():
+				return
+			case <-ac.snap.ticker.C:
+				ac.saveSnapshot()
+			}
+		}
+	}, "cache snapshot", logger.NewConsoleWriter())
+	return err
+}
+
+// saveSnapshot writes the snapshot file if enabled
+func (ac *AnyCache[T]) saveSnapshot() {
+	ac.snap.locker.Lock()
+	defer ac.snap.locker.Unlock()
+	if ac.snap.path == "" {
+		return
+	}
+	if err := ac.SaveFile(ac.snap.path); err != nil {
+		logger.NewConsoleWriter().Write(json.Bytes("cache snapshot error: " + err.Error() + "\n"))
+	}
+}
+
+// SaveTo writes the stored data to w as a json array in chronological order, the same as ToJSON.
+func (u *Ring[T]) SaveTo(w io.Writer) error {
+	b, err := json.Marshal(u.Slice())
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
+
+// LoadFrom clears the ring and stores the data written by SaveTo.
+// If there are more data than the ring can hold, only the latest ones are kept.
+func (u *Ring[T]) LoadFrom(r io.Reader) error {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	a := make([]T, 0)
+	if err = json.Unmarshal(b, &a); err != nil {
+		return err
+	}
+	u.Clear()
+	u.StoreMany(a...)
+	return nil
+}
+
+// SaveFile writes the ring to the file with an atomic rename, see AnyCache.SaveFile.
+func (u *Ring[T]) SaveFile(path string) error {
+	return writeFile(path, u.SaveTo)
+}
+
+// LoadFile restores the ring from the file, it returns nil if the file does not exist.
+func (u *Ring[T]) LoadFile(path string) error {
+	return readFile(path, u.LoadFrom)
+}
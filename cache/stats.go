@@ -0,0 +1,124 @@
+package cache
+
+import (
+	"sync/atomic"
+)
+
+// Stats is the statistics of a cache since it was created
+type Stats struct {
+	Hits        uint64 `json:"hits"`        // Load 命中次数
+	Misses      uint64 `json:"misses"`      // Load 未命中次数，包括已过期
+	Stores      uint64 `json:"stores"`      // 写入次数
+	Deletions   uint64 `json:"deletions"`   // 删除数量
+	Expirations uint64 `json:"expirations"` // 过期清理数量
+	Evictions   uint64 `json:"evictions"`   // 容量淘汰数量，包括未准入的新数据
+	Size        int    `json:"size"`        // 当前数量
+}
+
+// HitRatio returns hits / (hits + misses), or 0 if there is no lookup
+func (s Stats) HitRatio() float64 {
+	if s.Hits+s.Misses == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(s.Hits+s.Misses)
+}
+
+// counters 缓存统计计数
+type counters struct {
+	hits        atomic.Uint64
+	misses      atomic.Uint64
+	stores      atomic.Uint64
+	deletions   atomic.Uint64
+	expirations atomic.Uint64
+	evictions   atomic.Uint64
+}
+
+// add accumulates the counters into s
+func (c *counters) add(s *Stats) {
+	s.Hits += c.hits.Load()
+	s.Misses += c.misses.Load()
+	s.Stores += c.stores.Load()
+	s.Deletions += c.deletions.Load()
+	s.Expirations += c.expirations.Load()
+	s.Evictions += c.evictions.Load()
+}
+
+// Hooks are the optional callbacks of the cache events, they are called synchronously after the cache is unlocked,
+// so they may use the cache, but should not block.
+type Hooks[T any] struct {
+	// OnStore is called after an entry is stored
+	OnStore func(key string, value T)
+	// OnDelete is called after an entry is deleted by Delete, Clear does not call it
+	OnDelete func(key string, value T)
+	// OnExpire is called after an entry is cleaned up by expiration or evicted by the capacity,
+	// before the expire func of NewAnyCacheWithExpireFunc
+	OnExpire func(key string, value T)
+}
+
+// hooks 事件回调持有者
+type hooks[T any] struct {
+	p atomic.Pointer[Hooks[T]]
+}
+
+func (h *hooks[T]) stored(key string, value T, evicted map[string]T) {
+	x := h.p.Load()
+	if x == nil || x.OnStore == nil {
+		return
+	}
+	if _, ok := evicted[key]; ok { // 未准入
+		return
+	}
+	x.OnStore(key, value)
+}
+
+func (h *hooks[T]) deleted(data map[string]T) {
+	x := h.p.Load()
+	if x == nil || x.OnDelete == nil {
+		return
+	}
+	for k, v := range data {
+		x.OnDelete(k, v)
+	}
+}
+
+func (h *hooks[T]) expired(data map[string]T) {
+	x := h.p.Load()
+	if x == nil || x.OnExpire == nil {
+		return
+	}
+	for k, v := range data {
+		x.OnExpire(k, v)
+	}
+}
+
+// Stats returns the statistics of the cache
+func (ac *AnyCache[T]) Stats() Stats {
+	s := Stats{Size: ac.Len()}
+	ac.cache.counters.add(&s)
+	return s
+}
+
+// SetHooks sets the callbacks of the cache events, the previous ones are replaced.
+//
+// Example:
+//
+//	cache.SetHooks(Hooks[*Device]{
+//		OnExpire: func(key string, value *Device) { log.Println("device expired:", key) },
+//	})
+func (ac *AnyCache[T]) SetHooks(h Hooks[T]) {
+	ac.hooks.p.Store(&h)
+}
+
+// Stats returns the statistics of the cache, summed over the shards
+func (sc *ShardedCache[T]) Stats() Stats {
+	s := Stats{Size: sc.Len()}
+	for _, shard := range sc.shards {
+		shard.counters.add(&s)
+	}
+	return s
+}
+
+// SetHooks sets the callbacks of the cache events, see AnyCache.SetHooks.
+func (sc *ShardedCache[T]) SetHooks(h Hooks[T]) {
+	sc.hooks.p.Store(&h)
+}
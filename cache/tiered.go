@@ -0,0 +1,230 @@
+package cache
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// tieredStripes 版本号分段数量
+const tieredStripes = 64
+
+// Invalidator broadcasts the keys changed by one replica, so that the other replicas drop their local copies,
+// see TieredCache.Invalidate. An empty key list means all the keys.
+type Invalidator interface {
+	Publish(keys ...string) error
+}
+
+// TTLLoader is implemented by the caches that can report the remaining lifetime of an entry,
+// such as AnyCache and db.RedisCache. TieredCache uses it to keep the local copies from outliving the remote entries.
+type TTLLoader[T any] interface {
+	// LoadWithTTL returns the entry and its remaining lifetime, a negative ttl means the entry never expires.
+	LoadWithTTL(key string) (T, time.Duration, bool)
+}
+
+// TieredCache 两级缓存，优先读取本地缓存，未命中时读取远端缓存并回填本地
+type TieredCache[T any] struct {
+	local       *AnyCache[T]
+	remote      Cache[T]
+	localExpire time.Duration
+	invalidator Invalidator
+	versions    [tieredStripes]atomic.Uint64 // 按键分段的版本号，键被修改或失效时递增，避免回填覆盖并发的失效
+}
+
+// NewTieredCache creates a near cache in front of the remote cache, such as a db.RedisCache.
+// Load reads the local tier first, and populates the local tier from the remote tier on a local miss.
+// Writes go to the remote tier first, then the local tier, and are broadcast by the invalidator if set,
+// see SetInvalidator.
+// When the cache is no longer needed, it should be closed using the Close() method,
+// the remote cache is not closed since it is usually shared.
+//
+// A local copy never outlives the remote entry if the remote tier implements TTLLoader,
+// and a copy loaded concurrently with an invalidation of the same key is dropped instead of being kept.
+//
+// Parameters:
+//   - localExpire: The duration the local copies stay valid, it bounds how long a replica may serve a stale value
+//     when the invalidation broadcast is lost.
+//   - remote: The remote tier.
+//
+// Return:
+//   - A pointer to the newly created TieredCache instance.
+func NewTieredCache[T any](localExpire time.Duration, remote Cache[T]) *TieredCache[T] {
+	return &TieredCache[T]{
+		local:       NewAnyCache[T](localExpire),
+		remote:      remote,
+		localExpire: localExpire,
+	}
+}
+
+// Local returns the local tier, it can be used to set the capacity of the local copies, see AnyCache.SetCapacity.
+func (tc *TieredCache[T]) Local() *AnyCache[T] {
+	return tc.local
+}
+
+// SetInvalidator sets the invalidator used to broadcast the changed keys to the other replicas.
+// The replicas should call Invalidate when they receive the broadcast, see mq.CacheInvalidator.
+func (tc *TieredCache[T]) SetInvalidator(invalidator Invalidator) {
+	tc.invalidator = invalidator
+}
+
+// Invalidate drops the local copies of the keys, or all the local copies if no key is given.
+// It is called when the invalidation broadcast of another replica is received, the remote tier is not touched.
+func (tc *TieredCache[T]) Invalidate(keys ...string) {
+	tc.bump(keys...)
+	if len(keys) == 0 {
+		tc.local.Clear()
+		return
+	}
+	tc.local.DeleteMore(keys...)
+}
+
+// version returns the version counter of the key, fnv-1a hash inlined to avoid allocations
+func (tc *TieredCache[T]) version(key string) *atomic.Uint64 {
+	h := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		h ^= uint32(key[i])
+		h *= 16777619
+	}
+	return &tc.versions[h%tieredStripes]
+}
+
+// bump increases the versions of the keys, or all the versions if no key is given,
+// it must be called before the local copies are changed
+func (tc *TieredCache[T]) bump(keys ...string) {
+	if len(keys) == 0 {
+		for i := range tc.versions {
+			tc.versions[i].Add(1)
+		}
+		return
+	}
+	for _, k := range keys {
+		tc.version(k).Add(1)
+	}
+}
+
+// fill populates the local tier with the value loaded from the remote tier since version ver,
+// the copy is dropped if the key was changed or invalidated meanwhile
+func (tc *TieredCache[T]) fill(key string, value T, expire time.Duration, ver uint64) {
+	tc.local.StoreWithExpire(key, value, expire)
+	if tc.version(key).Load() != ver {
+		tc.local.Delete(key)
+	}
+}
+
+// localTTL returns the lifetime of the local copy of an entry with the remote expiration,
+// expire <= 0 means the remote entry never expires
+func (tc *TieredCache[T]) localTTL(expire time.Duration) time.Duration {
+	if expire <= 0 {
+		return tc.localExpire
+	}
+	return min(expire, tc.localExpire)
+}
+
+func (tc *TieredCache[T]) publish(keys ...string) {
+	if tc.invalidator != nil {
+		tc.invalidator.Publish(keys...)
+	}
+}
+
+// Close closes the local tier, the remote tier is not closed.
+func (tc *TieredCache[T]) Close() {
+	tc.local.Close()
+}
+
+// Clear clears both tiers and broadcasts the invalidation of all the keys.
+func (tc *TieredCache[T]) Clear() {
+	tc.remote.Clear()
+	tc.bump()
+	tc.local.Clear()
+	tc.publish()
+}
+
+// Len returns the number of entries in the remote tier.
+func (tc *TieredCache[T]) Len() int {
+	return tc.remote.Len()
+}
+
+// Extension extends the expiration time of the entry in both tiers.
+func (tc *TieredCache[T]) Extension(key string) {
+	tc.remote.Extension(key)
+	tc.local.Extension(key)
+}
+
+// Store adds an entry to both tiers, see StoreWithExpire.
+func (tc *TieredCache[T]) Store(key string, value T) error {
+	if err := tc.remote.Store(key, value); err != nil {
+		return err
+	}
+	tc.bump(key)
+	tc.local.Store(key, value)
+	tc.publish(key)
+	return nil
+}
+
+// StoreWithExpire adds an entry to the remote tier with the expiration, and to the local tier
+// with the shorter one of the expiration and the local expiration,
+// then broadcasts the invalidation of the key so that the other replicas reload it.
+// 0 expire means the remote entry never expires and the local copy lives for the local expiration,
+// a negative expire removes the entry from both tiers, the same as the remote tier does.
+func (tc *TieredCache[T]) StoreWithExpire(key string, value T, expire time.Duration) error {
+	if err := tc.remote.StoreWithExpire(key, value, expire); err != nil {
+		return err
+	}
+	tc.bump(key)
+	if expire < 0 {
+		tc.local.Delete(key)
+	} else {
+		tc.local.StoreWithExpire(key, value, tc.localTTL(expire))
+	}
+	tc.publish(key)
+	return nil
+}
+
+// Load reads the entry from the local tier, or from the remote tier and populates the local tier.
+// The local copy expires with the remote entry if the remote tier implements TTLLoader,
+// otherwise it lives for the local expiration.
+func (tc *TieredCache[T]) Load(key string) (T, bool) {
+	if v, ok := tc.local.Load(key); ok {
+		return v, true
+	}
+	ver := tc.version(key).Load()
+	if r, ok := tc.remote.(TTLLoader[T]); ok {
+		v, ttl, ok := r.LoadWithTTL(key)
+		if ok && ttl != 0 {
+			expire := tc.localExpire
+			if ttl > 0 {
+				expire = min(ttl, tc.localExpire)
+			}
+			tc.fill(key, v, expire, ver)
+		}
+		return v, ok
+	}
+	v, ok := tc.remote.Load(key)
+	if ok {
+		tc.fill(key, v, tc.localExpire, ver)
+	}
+	return v, ok
+}
+
+// LoadOrStore reads the entry like Load, or stores the value to the remote tier if it does not exist.
+func (tc *TieredCache[T]) LoadOrStore(key string, value T) (T, bool) {
+	if v, ok := tc.local.Load(key); ok {
+		return v, true
+	}
+	ver := tc.version(key).Load()
+	v, ok := tc.remote.LoadOrStore(key, value)
+	tc.fill(key, v, tc.localExpire, ver)
+	return v, ok
+}
+
+// Delete removes the entry from both tiers and broadcasts the invalidation of the key.
+func (tc *TieredCache[T]) Delete(key string) {
+	tc.remote.Delete(key)
+	tc.bump(key)
+	tc.local.Delete(key)
+	tc.publish(key)
+}
+
+// ForEach iterates over the entries of the remote tier.
+func (tc *TieredCache[T]) ForEach(f func(key string, value T) bool) {
+	tc.remote.ForEach(f)
+}
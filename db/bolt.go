@@ -144,6 +144,22 @@ func (c *BoltDB) Health() error {
 	return err
 }
 
+// Update executes f in a read-write transaction, the transaction is rolled back if f returns an error
+func (c *BoltDB) Update(f func(tx *bbolt.Tx) error) error {
+	if c.cli == nil {
+		return fmt.Errorf("bolt client is not initialized")
+	}
+	return c.cli.Update(f)
+}
+
+// View executes f in a read-only transaction
+func (c *BoltDB) View(f func(tx *bbolt.Tx) error) error {
+	if c.cli == nil {
+		return fmt.Errorf("bolt client is not initialized")
+	}
+	return c.cli.View(f)
+}
+
 func (c *BoltDB) Close() error {
 	if c.cli == nil {
 		return nil
@@ -0,0 +1,295 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/xyzj/toolbox/cache"
+	"github.com/xyzj/toolbox/json"
+)
+
+var (
+	_ cache.Cache[*QueryData]     = &RedisCache[*QueryData]{}
+	_ cache.TTLLoader[*QueryData] = &RedisCache[*QueryData]{}
+)
+
+// Serializer 缓存数据序列化方法
+type Serializer interface {
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+}
+
+// JSONSerializer 使用json序列化
+type JSONSerializer struct{}
+
+func (JSONSerializer) Marshal(v any) ([]byte, error)      { return json.Marshal(v) }
+func (JSONSerializer) Unmarshal(data []byte, v any) error { return json.Unmarshal(data, v) }
+
+// GobSerializer 使用gob序列化，适用于json无法完整还原的数据类型，如接口字段需先gob.Register
+type GobSerializer struct{}
+
+func (GobSerializer) Marshal(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (GobSerializer) Unmarshal(data []byte, v any) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+// RedisCache redis缓存，实现cache.Cache接口，可在多个进程间共享，重启后不丢失
+type RedisCache[T any] struct {
+	cli         *redis.Client
+	prefix      string
+	match       string // SCAN MATCH 使用的模式，前缀中的通配符已转义
+	cacheExpire time.Duration
+	timeout     time.Duration
+	serializer  Serializer
+	closed      atomic.Bool
+}
+
+// NewRedisCache creates a cache stored in redis, it implements cache.Cache and can be used as Opt.QueryCache.
+// The keys are stored as prefix:key, so that several caches can share one redis database.
+// Closing the cache does not close the redis client.
+//
+// Parameters:
+//   - cli: The redis client.
+//   - prefix: The key prefix of this cache.
+//   - expire: The default expiration of the entries, 0 means never expire.
+//   - serializer: The serializer of the values, nil means JSONSerializer.
+//
+// Return:
+//   - A pointer to the newly created RedisCache instance.
+//
+// Example:
+//
+//	opt.QueryCache = NewRedisCache[*QueryData](rdb, "query", time.Minute*30, GobSerializer{})
+func NewRedisCache[T any](cli *RedisCli, prefix string, expire time.Duration, serializer Serializer) *RedisCache[T] {
+	if serializer == nil {
+		serializer = JSONSerializer{}
+	}
+	return &RedisCache[T]{
+		cli:         cli.Cli(),
+		prefix:      prefix + ":",
+		match:       escapeGlob(prefix+":") + "*",
+		cacheExpire: expire,
+		timeout:     time.Second * 5,
+		serializer:  serializer,
+	}
+}
+
+// escapeGlob escapes the glob metacharacters of redis patterns, so that s is matched literally
+func escapeGlob(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "*", `\*`, "?", `\?`, "[", `\[`, "]", `\]`).Replace(s)
+}
+
+func (rc *RedisCache[T]) context() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), rc.timeout)
+}
+
+// scan iterates over the keys of this cache page by page
+func (rc *RedisCache[T]) scan(f func(keys []string) bool) error {
+	var cursor uint64
+	for {
+		ctx, cancel := rc.context()
+		keys, next, err := rc.cli.Scan(ctx, cursor, rc.match, defaultBatchSize).Result()
+		cancel()
+		if err != nil {
+			return err
+		}
+		if len(keys) > 0 && !f(keys) {
+			return nil
+		}
+		if next == 0 {
+			return nil
+		}
+		cursor = next
+	}
+}
+
+// Close marks the cache closed, the redis client is not closed.
+func (rc *RedisCache[T]) Close() {
+	rc.closed.Store(true)
+}
+
+// Clear removes all the entries of this cache from redis.
+func (rc *RedisCache[T]) Clear() {
+	if rc.closed.Load() {
+		return
+	}
+	rc.scan(func(keys []string) bool {
+		ctx, cancel := rc.context()
+		defer cancel()
+		rc.cli.Unlink(ctx, keys...)
+		return true
+	})
+}
+
+// Len returns the number of entries of this cache, it scans all the keys, do not call it frequently.
+func (rc *RedisCache[T]) Len() int {
+	if rc.closed.Load() {
+		return 0
+	}
+	n := 0
+	rc.scan(func(keys []string) bool {
+		n += len(keys)
+		return true
+	})
+	return n
+}
+
+// Extension resets the expiration of the entry to the default expiration.
+func (rc *RedisCache[T]) Extension(key string) {
+	if rc.closed.Load() || rc.cacheExpire <= 0 {
+		return
+	}
+	ctx, cancel := rc.context()
+	defer cancel()
+	rc.cli.Expire(ctx, rc.prefix+key, rc.cacheExpire)
+}
+
+// Store adds an entry with the default expiration.
+func (rc *RedisCache[T]) Store(key string, value T) error {
+	return rc.StoreWithExpire(key, value, rc.cacheExpire)
+}
+
+// StoreWithExpire adds an entry with the specified expiration,
+// 0 means never expire, and a negative expiration removes the entry.
+func (rc *RedisCache[T]) StoreWithExpire(key string, value T, expire time.Duration) error {
+	if rc.closed.Load() {
+		return fmt.Errorf("cache is closed")
+	}
+	ctx, cancel := rc.context()
+	defer cancel()
+	if expire < 0 {
+		return rc.cli.Del(ctx, rc.prefix+key).Err()
+	}
+	b, err := rc.serializer.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return rc.cli.Set(ctx, rc.prefix+key, b, expire).Err()
+}
+
+// Load returns the entry, false if it is not found, expired, or redis is not available.
+func (rc *RedisCache[T]) Load(key string) (T, bool) {
+	var v T
+	if rc.closed.Load() {
+		return v, false
+	}
+	ctx, cancel := rc.context()
+	defer cancel()
+	b, err := rc.cli.Get(ctx, rc.prefix+key).Bytes()
+	if err != nil {
+		return v, false
+	}
+	if err = rc.serializer.Unmarshal(b, &v); err != nil {
+		return v, false
+	}
+	return v, true
+}
+
+// LoadWithTTL returns the entry and its remaining lifetime, a negative ttl means the entry never expires,
+// see cache.TTLLoader.
+func (rc *RedisCache[T]) LoadWithTTL(key string) (T, time.Duration, bool) {
+	var v T
+	if rc.closed.Load() {
+		return v, 0, false
+	}
+	ctx, cancel := rc.context()
+	defer cancel()
+	var get *redis.StringCmd
+	var ttl *redis.DurationCmd
+	if _, err := rc.cli.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		get = pipe.Get(ctx, rc.prefix+key)
+		ttl = pipe.PTTL(ctx, rc.prefix+key)
+		return nil
+	}); err != nil {
+		return v, 0, false
+	}
+	b, _ := get.Bytes()
+	if err := rc.serializer.Unmarshal(b, &v); err != nil {
+		return v, 0, false
+	}
+	d := ttl.Val()
+	if d == -2 { // 读取后过期
+		return v, 0, false
+	}
+	if d < 0 {
+		d = -1
+	}
+	return v, d, true
+}
+
+// LoadOrStore returns the existing entry and true, or stores the value and returns it with false.
+func (rc *RedisCache[T]) LoadOrStore(key string, value T) (T, bool) {
+	if rc.closed.Load() {
+		var zero T
+		return zero, false
+	}
+	b, err := rc.serializer.Marshal(value)
+	if err != nil {
+		var zero T
+		return zero, false
+	}
+	ctx, cancel := rc.context()
+	defer cancel()
+	ok, err := rc.cli.SetNX(ctx, rc.prefix+key, b, rc.cacheExpire).Result()
+	if err != nil || ok {
+		return value, false
+	}
+	if v, ok := rc.Load(key); ok {
+		return v, true
+	}
+	return value, false
+}
+
+// Delete removes the entry.
+func (rc *RedisCache[T]) Delete(key string) {
+	if rc.closed.Load() {
+		return
+	}
+	ctx, cancel := rc.context()
+	defer cancel()
+	rc.cli.Del(ctx, rc.prefix+key)
+}
+
+// ForEach iterates over the entries with SCAN, the values are fetched in batches with MGET.
+// Entries changed during the iteration may or may not be visited, the same as SCAN.
+// If the function returns false, the iteration will be stopped.
+func (rc *RedisCache[T]) ForEach(f func(key string, value T) bool) {
+	if rc.closed.Load() {
+		return
+	}
+	rc.scan(func(keys []string) bool {
+		ctx, cancel := rc.context()
+		defer cancel()
+		vals, err := rc.cli.MGet(ctx, keys...).Result()
+		if err != nil {
+			return false
+		}
+		for i, val := range vals {
+			s, ok := val.(string)
+			if !ok { // 已过期或被删除
+				continue
+			}
+			var v T
+			if err := rc.serializer.Unmarshal([]byte(s), &v); err != nil {
+				continue
+			}
+			if !f(keys[i][len(rc.prefix):], v) {
+				return false
+			}
+		}
+		return true
+	})
+}
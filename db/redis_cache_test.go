@@ -0,0 +1,395 @@
+package db
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"path"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// fakeRedis 实现测试需要的少量 redis 命令，使用 RESP2 协议
+type fakeRedis struct {
+	locker sync.Mutex
+	data   map[string]string
+	expire map[string]time.Time
+	order  []string // 键的写入顺序，删除后保留，使 SCAN 的游标在删除时保持稳定
+	ln     net.Listener
+}
+
+func newFakeRedis(t *testing.T) *fakeRedis {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeRedis{
+		data:   make(map[string]string),
+		expire: make(map[string]time.Time),
+		ln:     ln,
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+	t.Cleanup(func() { ln.Close() })
+	return f
+}
+
+// client returns a RedisCli connected to the fake server
+func (f *fakeRedis) client(t *testing.T) *RedisCli {
+	rdb := NewRedisClient(WithRedisAddr(f.ln.Addr().String()), func(o *redis.Options) {
+		o.Protocol = 2
+		o.DisableIdentity = true
+	})
+	t.Cleanup(func() { rdb.Close() })
+	return rdb
+}
+
+// ttl returns the remaining ttl of the key, -1 if it never expires, false if it does not exist
+func (f *fakeRedis) ttl(key string) (time.Duration, bool) {
+	f.locker.Lock()
+	defer f.locker.Unlock()
+	if !f.exists(key) {
+		return 0, false
+	}
+	if e, ok := f.expire[key]; ok {
+		return time.Until(e), true
+	}
+	return -1, true
+}
+
+func (f *fakeRedis) keys() []string {
+	f.locker.Lock()
+	defer f.locker.Unlock()
+	keys := make([]string, 0, len(f.data))
+	for k := range f.data {
+		if f.exists(k) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// exists reports whether the key exists and is not expired, the caller must hold the lock
+func (f *fakeRedis) exists(key string) bool {
+	if _, ok := f.data[key]; !ok {
+		return false
+	}
+	if e, ok := f.expire[key]; ok && !e.After(time.Now()) {
+		delete(f.data, key)
+		delete(f.expire, key)
+		return false
+	}
+	return true
+}
+
+// set saves the value, the caller must hold the lock
+func (f *fakeRedis) set(key, value string) {
+	if !slices.Contains(f.order, key) {
+		f.order = append(f.order, key)
+	}
+	f.data[key] = value
+}
+
+func (f *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			return
+		}
+		f.locker.Lock()
+		f.exec(w, args)
+		f.locker.Unlock()
+		if r.Buffered() == 0 {
+			w.Flush()
+		}
+	}
+}
+
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(line, "*") {
+		return nil, fmt.Errorf("unexpected request: %q", line)
+	}
+	n, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+	args := make([]string, n)
+	for i := range args {
+		line, err = r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		size, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+		b := make([]byte, size+2)
+		if _, err = io.ReadFull(r, b); err != nil {
+			return nil, err
+		}
+		args[i] = string(b[:size])
+	}
+	return args, nil
+}
+
+func writeBulk(w *bufio.Writer, s string) {
+	fmt.Fprintf(w, "$%d\r\n%s\r\n", len(s), s)
+}
+
+// exec executes a command with the lock held
+func (f *fakeRedis) exec(w *bufio.Writer, args []string) {
+	switch strings.ToUpper(args[0]) {
+	case "PING":
+		w.WriteString("+PONG\r\n")
+	case "INFO":
+		writeBulk(w, "# Server\r\nredis_version:7.2.0\r\n")
+	case "GET":
+		if !f.exists(args[1]) {
+			w.WriteString("$-1\r\n")
+			return
+		}
+		writeBulk(w, f.data[args[1]])
+	case "SET":
+		var expire time.Duration
+		nx := false
+		for i := 3; i < len(args); i++ {
+			switch strings.ToUpper(args[i]) {
+			case "EX":
+				n, _ := strconv.Atoi(args[i+1])
+				expire, i = time.Duration(n)*time.Second, i+1
+			case "PX":
+				n, _ := strconv.Atoi(args[i+1])
+				expire, i = time.Duration(n)*time.Millisecond, i+1
+			case "NX":
+				nx = true
+			}
+		}
+		if nx && f.exists(args[1]) {
+			w.WriteString("$-1\r\n")
+			return
+		}
+		f.set(args[1], args[2])
+		delete(f.expire, args[1])
+		if expire > 0 {
+			f.expire[args[1]] = time.Now().Add(expire)
+		}
+		w.WriteString("+OK\r\n")
+	case "SETNX":
+		if f.exists(args[1]) {
+			w.WriteString(":0\r\n")
+			return
+		}
+		f.set(args[1], args[2])
+		w.WriteString(":1\r\n")
+	case "DEL", "UNLINK":
+		n := 0
+		for _, k := range args[1:] {
+			if f.exists(k) {
+				n++
+			}
+			delete(f.data, k)
+			delete(f.expire, k)
+		}
+		fmt.Fprintf(w, ":%d\r\n", n)
+	case "EXPIRE", "PEXPIRE":
+		n, _ := strconv.Atoi(args[2])
+		d := time.Duration(n) * time.Second
+		if strings.ToUpper(args[0]) == "PEXPIRE" {
+			d = time.Duration(n) * time.Millisecond
+		}
+		if !f.exists(args[1]) {
+			w.WriteString(":0\r\n")
+			return
+		}
+		f.expire[args[1]] = time.Now().Add(d)
+		w.WriteString(":1\r\n")
+	case "PTTL":
+		switch {
+		case !f.exists(args[1]):
+			w.WriteString(":-2\r\n")
+		case f.expire[args[1]].IsZero():
+			w.WriteString(":-1\r\n")
+		default:
+			fmt.Fprintf(w, ":%d\r\n", time.Until(f.expire[args[1]]).Milliseconds())
+		}
+	case "MGET":
+		fmt.Fprintf(w, "*%d\r\n", len(args)-1)
+		for _, k := range args[1:] {
+			if f.exists(k) {
+				writeBulk(w, f.data[k])
+			} else {
+				w.WriteString("$-1\r\n")
+			}
+		}
+	case "SCAN":
+		// 每页固定返回最多10个键，用于测试分页
+		cursor, _ := strconv.Atoi(args[1])
+		match := "*"
+		for i := 2; i < len(args)-1; i++ {
+			if strings.ToUpper(args[i]) == "MATCH" {
+				match = args[i+1]
+			}
+		}
+		keys := f.order
+		end := min(cursor+10, len(keys))
+		page := make([]string, 0, 10)
+		for _, k := range keys[cursor:end] {
+			if ok, _ := path.Match(match, k); ok && f.exists(k) {
+				page = append(page, k)
+			}
+		}
+		next := end
+		if end >= len(keys) {
+			next = 0
+		}
+		w.WriteString("*2\r\n")
+		writeBulk(w, strconv.Itoa(next))
+		fmt.Fprintf(w, "*%d\r\n", len(page))
+		for _, k := range page {
+			writeBulk(w, k)
+		}
+	default:
+		fmt.Fprintf(w, "-ERR unknown command '%s'\r\n", args[0])
+	}
+}
+
+type cacheItem struct {
+	Name  string
+	Value int
+	Tags  []string
+}
+
+func TestSerializer(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		s    Serializer
+	}{
+		{name: "json", s: JSONSerializer{}},
+		{name: "gob", s: GobSerializer{}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			want := &cacheItem{Name: "a", Value: 1, Tags: []string{"x", "y"}}
+			b, err := tt.s.Marshal(want)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := &cacheItem{}
+			if err = tt.s.Unmarshal(b, &got); err != nil {
+				t.Fatal(err)
+			}
+			if got.Name != want.Name || got.Value != want.Value || strings.Join(got.Tags, ",") != "x,y" {
+				t.Fatalf("round trip mismatch: %+v", got)
+			}
+		})
+	}
+}
+
+func TestRedisCache(t *testing.T) {
+	f := newFakeRedis(t)
+	rdb := f.client(t)
+	rc := NewRedisCache[*cacheItem](rdb, "dev", time.Minute, GobSerializer{})
+	defer rc.Close()
+
+	// 键以 prefix: 保存
+	if err := rc.Store("a", &cacheItem{Name: "a", Value: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if d, ok := f.ttl("dev:a"); !ok || d <= 0 || d > time.Minute {
+		t.Fatalf("expect default expiration, got %v %v", d, ok)
+	}
+	if v, ok := rc.Load("a"); !ok || v.Value != 1 {
+		t.Fatalf("unexpected value: %+v %v", v, ok)
+	}
+
+	// 过期时间的对应关系：0 不过期，负数删除
+	rc.StoreWithExpire("never", &cacheItem{}, 0)
+	if d, ok := f.ttl("dev:never"); !ok || d != -1 {
+		t.Fatalf("expect no expiration, got %v %v", d, ok)
+	}
+	rc.StoreWithExpire("short", &cacheItem{}, time.Millisecond*1500)
+	if d, ok := f.ttl("dev:short"); !ok || d <= time.Second || d > time.Millisecond*1500 {
+		t.Fatalf("unexpected ttl: %v %v", d, ok)
+	}
+	rc.StoreWithExpire("short", &cacheItem{}, -1)
+	if _, ok := f.ttl("dev:short"); ok {
+		t.Fatal("expect negative expiration to remove the entry")
+	}
+
+	if _, ttl, ok := rc.LoadWithTTL("never"); !ok || ttl >= 0 {
+		t.Fatalf("expect no expiration, got %v %v", ttl, ok)
+	}
+	if v, ttl, ok := rc.LoadWithTTL("a"); !ok || v.Value != 1 || ttl <= 0 || ttl > time.Minute {
+		t.Fatalf("unexpected entry: %+v %v %v", v, ttl, ok)
+	}
+	if _, _, ok := rc.LoadWithTTL("missing"); ok {
+		t.Fatal("expect missing entry not found")
+	}
+
+	if v, ok := rc.LoadOrStore("b", &cacheItem{Value: 2}); ok || v.Value != 2 {
+		t.Fatalf("expect stored, got %+v %v", v, ok)
+	}
+	if v, ok := rc.LoadOrStore("b", &cacheItem{Value: 3}); !ok || v.Value != 2 {
+		t.Fatalf("expect loaded, got %+v %v", v, ok)
+	}
+}
+
+func TestRedisCacheScan(t *testing.T) {
+	f := newFakeRedis(t)
+	rdb := f.client(t)
+	// 前缀中的通配符按字面匹配，不会扫描到其他缓存的键
+	rc := NewRedisCache[int](rdb, "dev*", 0, nil)
+	other := NewRedisCache[int](rdb, "device", 0, nil)
+	for i := range 25 {
+		rc.Store(strconv.Itoa(i), i)
+		other.Store(strconv.Itoa(i), i)
+	}
+
+	if n := rc.Len(); n != 25 {
+		t.Fatalf("expect 25 entries, got %d", n)
+	}
+	sum, visited := 0, 0
+	rc.ForEach(func(key string, value int) bool {
+		if key != strconv.Itoa(value) {
+			t.Fatalf("unexpected entry: %s=%d", key, value)
+		}
+		sum += value
+		visited++
+		return true
+	})
+	if visited != 25 || sum != 300 {
+		t.Fatalf("unexpected iteration: visited=%d sum=%d", visited, sum)
+	}
+	visited = 0
+	rc.ForEach(func(string, int) bool {
+		visited++
+		return visited < 3
+	})
+	if visited != 3 {
+		t.Fatalf("expect the iteration stopped, visited=%d", visited)
+	}
+
+	rc.Clear()
+	if n := rc.Len(); n != 0 {
+		t.Fatalf("expect cleared, got %d", n)
+	}
+	if n := other.Len(); n != 25 || len(f.keys()) != 25 {
+		t.Fatalf("expect other cache untouched, got %d", n)
+	}
+}
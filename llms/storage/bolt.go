@@ -0,0 +1,109 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/xyzj/toolbox/db"
+	"github.com/xyzj/toolbox/llms"
+	"github.com/xyzj/toolbox/logger"
+	"go.etcd.io/bbolt"
+)
+
+// BoltStorage stores the chats in the namespace bucket of a bolt file, keyed by the chat id,
+// so the chats can share one file with other data, such as storage.Bolt histories.
+type BoltStorage struct {
+	db     *db.BoltDB
+	bucket []byte
+	logg   logger.Logger
+}
+
+// NewBoltStorage creates a chat storage in the namespace bucket of the bolt file, an empty namespace means "chats".
+// The errors that can not be returned, such as the unparsable records skipped by Load, are written to logg,
+// a nil logg means the console logger.
+func NewBoltStorage(bdb *db.BoltDB, namespace string, logg logger.Logger) llms.Storage {
+	if namespace == "" {
+		namespace = "chats"
+	}
+	if logg == nil {
+		logg = logger.NewConsoleLogger()
+	}
+	return &BoltStorage{
+		db:     bdb,
+		bucket: []byte(namespace),
+		logg:   logg,
+	}
+}
+
+func (s *BoltStorage) Clear() {
+	s.db.Update(func(tx *bbolt.Tx) error {
+		err := tx.DeleteBucket(s.bucket)
+		if errors.Is(err, bbolt.ErrBucketNotFound) {
+			return nil
+		}
+		return err
+	})
+}
+
+// Load returns all the chats, the records that can not be parsed are skipped and logged
+func (s *BoltStorage) Load() (map[string]*llms.ChatData, error) {
+	data := make(map[string]*llms.ChatData)
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(s.bucket)
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			x := &llms.ChatData{}
+			if err := x.FromJSON(string(v)); err != nil {
+				s.logg.Warning(fmt.Sprintf("[bolt] skip unparsable chat %s: %s", k, err.Error()))
+				return nil
+			}
+			data[string(k)] = x
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func (s *BoltStorage) Store(d *llms.ChatData) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(s.bucket)
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(d.ID), []byte(d.ToJSON()))
+	})
+}
+
+// RemoveDead deletes the chats not updated for t in one transaction, the error is logged.
+// The records that can not be parsed are kept, so that they are not lost silently, see Load.
+func (s *BoltStorage) RemoveDead(t time.Duration) {
+	err := s.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(s.bucket)
+		if b == nil {
+			return nil
+		}
+		dead := make([][]byte, 0)
+		b.ForEach(func(k, v []byte) error {
+			x := &llms.ChatData{}
+			if err := x.FromJSON(string(v)); err == nil && time.Since(time.Unix(x.LastUpdate, 0)) > t {
+				dead = append(dead, k)
+			}
+			return nil
+		})
+		for _, k := range dead {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		s.logg.Error("[bolt] remove dead chats error: " + err.Error())
+	}
+}
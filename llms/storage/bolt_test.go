@@ -0,0 +1,101 @@
+package storage
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/xyzj/toolbox/db"
+	"github.com/xyzj/toolbox/llms"
+	"github.com/xyzj/toolbox/logger"
+	history "github.com/xyzj/toolbox/storage"
+	"go.etcd.io/bbolt"
+)
+
+func newTestBolt(t *testing.T) *db.BoltDB {
+	t.Helper()
+	bdb, err := db.NewBolt(filepath.Join(t.TempDir(), "chat.db"))
+	if err != nil {
+		t.Fatalf("open bolt failed: %v", err)
+	}
+	t.Cleanup(func() { _ = bdb.Close() })
+	return bdb
+}
+
+func TestBoltStorage(t *testing.T) {
+	bdb := newTestBolt(t)
+	s := NewBoltStorage(bdb, "", &logger.NilLogger{})
+	now := time.Now().Unix()
+	for _, d := range []*llms.ChatData{
+		{ID: "alive", Model: "m1", LastUpdate: now},
+		{ID: "dead", Model: "m2", LastUpdate: now - 7200},
+	} {
+		if err := s.Store(d); err != nil {
+			t.Fatalf("store failed: %v", err)
+		}
+	}
+	data, err := s.Load()
+	if err != nil {
+		t.Fatalf("load failed: %v", err)
+	}
+	if len(data) != 2 || data["alive"].Model != "m1" || data["dead"].LastUpdate != now-7200 {
+		t.Fatalf("unexpected chats: %v", data)
+	}
+
+	// 无法解析的记录在加载时跳过，也不会被当作过期数据删除
+	bdb.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket([]byte("chats")).Put([]byte("broken"), []byte("{"))
+	})
+	if data, err = s.Load(); err != nil || len(data) != 2 || data["broken"] != nil {
+		t.Fatalf("expect the broken chat skipped, got %v %v", data, err)
+	}
+	s.RemoveDead(time.Hour)
+	bdb.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte("chats"))
+		if b.Get([]byte("dead")) != nil || b.Get([]byte("alive")) == nil || b.Get([]byte("broken")) == nil {
+			t.Fatal("expect only the dead chat removed")
+		}
+		return nil
+	})
+
+	s.Clear()
+	bdb.Update(func(tx *bbolt.Tx) error {
+		if tx.Bucket([]byte("chats")) != nil {
+			t.Fatal("expect the bucket removed")
+		}
+		return nil
+	})
+	if data, err = s.Load(); err != nil || len(data) != 0 {
+		t.Fatalf("expect empty after clear, got %v %v", data, err)
+	}
+}
+
+func TestBoltStorageSharedFile(t *testing.T) {
+	bdb := newTestBolt(t)
+	// 使用默认命名空间的聊天记录和历史记录共享同一个文件，互不影响
+	chats := NewBoltStorage(bdb, "", &logger.NilLogger{})
+	lines := history.NewBolt(bdb, "", 10)
+	if err := chats.Store(&llms.ChatData{ID: "c1", LastUpdate: time.Now().Unix()}); err != nil {
+		t.Fatalf("store failed: %v", err)
+	}
+	if err := lines.Store("l1", "l2"); err != nil {
+		t.Fatalf("store failed: %v", err)
+	}
+
+	data, err := chats.Load()
+	if err != nil || len(data) != 1 || data["c1"] == nil {
+		t.Fatalf("unexpected chats: %v %v", data, err)
+	}
+	if got, err := lines.Load(); err != nil || len(got) != 2 {
+		t.Fatalf("unexpected history: %v %v", got, err)
+	}
+
+	chats.Clear()
+	if got, _ := lines.Load(); len(got) != 2 {
+		t.Fatalf("clearing the chats should not touch the history, got %v", got)
+	}
+	chats.RemoveDead(0)
+	if got, _ := lines.Load(); len(got) != 2 {
+		t.Fatalf("removing dead chats should not touch the history, got %v", got)
+	}
+}
@@ -0,0 +1,194 @@
+package loopfunc
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/xyzj/toolbox/json"
+)
+
+var (
+	// ErrPoolStopped is returned by Submit after StopAndWait is called
+	ErrPoolStopped = errors.New("pool is stopped")
+	// ErrTaskPanic is the error of the future whose task panicked
+	ErrTaskPanic = errors.New("task panic")
+)
+
+// Task 提交给 Pool 执行的任务，ctx 在提交方取消或任务超时时结束，任务应及时响应
+type Task func(ctx context.Context) (any, error)
+
+// Future is the result of a submitted task
+type Future struct {
+	done chan struct{}
+	val  any
+	err  error
+}
+
+// Done returns a channel closed when the task is finished
+func (f *Future) Done() <-chan struct{} {
+	return f.done
+}
+
+// Result waits for the task to finish and returns its result.
+// If the task panicked, the error wraps ErrTaskPanic.
+func (f *Future) Result() (any, error) {
+	<-f.done
+	return f.val, f.err
+}
+
+// Wait waits for the task like Result, but returns the error of ctx if ctx is done first, the task keeps running.
+func (f *Future) Wait(ctx context.Context) (any, error) {
+	select {
+	case <-f.done:
+		return f.val, f.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+type poolTask struct {
+	ctx     context.Context
+	timeout time.Duration
+	task    Task
+	future  *Future
+}
+
+// Pool is a fixed number of workers running the submitted tasks from a bounded queue,
+// a panic of a task is recovered and written to the crash log writer, the worker keeps running.
+type Pool struct {
+	locker    sync.RWMutex
+	tasks     chan *poolTask
+	quit      chan struct{}
+	quitOnce  sync.Once
+	wg        sync.WaitGroup
+	logWriter io.Writer
+	timeout   atomic.Int64 // 默认任务超时
+	stopped   bool
+}
+
+// NewPool creates a pool and starts its workers.
+//
+// Parameters:
+//   - size: The number of the workers, at least 1.
+//   - queueLen: The number of the tasks waiting for a worker, Submit blocks when the queue is full.
+//
+// Example:
+//
+//	pool := loopfunc.NewPool(8, 100)
+//	pool.SetLogWriter(&loopfunc.CrashLogger{FilePath: "log/crash.log"})
+//	for _, file := range files {
+//		f, err := pool.Submit(ctx, func(ctx context.Context) (any, error) {
+//			return importFile(ctx, file)
+//		})
+//		...
+//	}
+//	pool.StopAndWait()
+func NewPool(size, queueLen int) *Pool {
+	p := &Pool{
+		tasks:     make(chan *poolTask, max(queueLen, 0)),
+		quit:      make(chan struct{}),
+		logWriter: os.Stdout,
+	}
+	for range max(size, 1) {
+		p.wg.Add(1)
+		go p.work()
+	}
+	return p
+}
+
+// SetLogWriter sets the writer of the task panics, such as a CrashLogger, default os.Stdout.
+// Call it before the tasks are submitted.
+func (p *Pool) SetLogWriter(w io.Writer) {
+	if w == nil {
+		w = os.Stdout
+	}
+	p.logWriter = w
+}
+
+// SetTimeout sets the default timeout of the tasks submitted by Submit, 0 means no timeout
+func (p *Pool) SetTimeout(timeout time.Duration) {
+	p.timeout.Store(int64(max(timeout, 0)))
+}
+
+// Submit queues the task with the default timeout, see SubmitWithTimeout.
+func (p *Pool) Submit(ctx context.Context, task Task) (*Future, error) {
+	return p.SubmitWithTimeout(ctx, time.Duration(p.timeout.Load()), task)
+}
+
+// SubmitWithTimeout queues the task, it blocks while the queue is full.
+// The task runs with ctx limited by the timeout, a task whose ctx is done before it starts is skipped
+// and its future returns the error of ctx.
+//
+// Return:
+//   - The future of the task.
+//   - The error of ctx if ctx is done before the task is queued, or ErrPoolStopped.
+func (p *Pool) SubmitWithTimeout(ctx context.Context, timeout time.Duration, task Task) (*Future, error) {
+	p.locker.RLock()
+	defer p.locker.RUnlock()
+	if p.stopped {
+		return nil, ErrPoolStopped
+	}
+	t := &poolTask{
+		ctx:     ctx,
+		timeout: timeout,
+		task:    task,
+		future:  &Future{done: make(chan struct{})},
+	}
+	select {
+	case p.tasks <- t:
+		return t.future, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-p.quit:
+		return nil, ErrPoolStopped
+	}
+}
+
+// StopAndWait stops accepting new tasks, waits for the queued and running tasks to finish, then stops the workers.
+// The blocked Submit calls return ErrPoolStopped.
+func (p *Pool) StopAndWait() {
+	p.quitOnce.Do(func() {
+		close(p.quit)
+		p.locker.Lock()
+		p.stopped = true
+		close(p.tasks)
+		p.locker.Unlock()
+	})
+	p.wg.Wait()
+}
+
+func (p *Pool) work() {
+	defer p.wg.Done()
+	for t := range p.tasks {
+		p.run(t)
+	}
+}
+
+// run executes the task and recovers its panic
+func (p *Pool) run(t *poolTask) {
+	defer close(t.future.done)
+	if err := t.ctx.Err(); err != nil {
+		t.future.err = err
+		return
+	}
+	ctx := t.ctx
+	if t.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, t.timeout)
+		defer cancel()
+	}
+	defer func() {
+		if err := recover(); err != nil {
+			p.logWriter.Write(json.Bytes(fmt.Sprintf("pool task [POOL] crash: %+v\n", errors.WithStack(fmt.Errorf("%v", err)))))
+			t.future.val = nil
+			t.future.err = fmt.Errorf("%w: %v", ErrTaskPanic, err)
+		}
+	}()
+	t.future.val, t.future.err = t.task(ctx)
+}
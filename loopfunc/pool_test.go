@@ -0,0 +1,64 @@
+package loopfunc
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPool(t *testing.T) {
+	p := NewPool(2, 4)
+	buf := &bytes.Buffer{}
+	p.SetLogWriter(buf)
+
+	f, err := p.Submit(context.Background(), func(ctx context.Context) (any, error) {
+		return 42, nil
+	})
+	if err != nil {
+		t.Fatalf("submit failed: %v", err)
+	}
+	if v, err := f.Result(); err != nil || v != 42 {
+		t.Fatalf("unexpected result: %v %v", v, err)
+	}
+
+	f, _ = p.Submit(context.Background(), func(ctx context.Context) (any, error) {
+		panic("boom")
+	})
+	if _, err = f.Result(); !errors.Is(err, ErrTaskPanic) {
+		t.Fatalf("expected ErrTaskPanic, got %v", err)
+	}
+	if !strings.Contains(buf.String(), "boom") {
+		t.Fatalf("panic should be logged, got %q", buf.String())
+	}
+
+	f, _ = p.SubmitWithTimeout(context.Background(), time.Millisecond*10, func(ctx context.Context) (any, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	if _, err = f.Result(); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected timeout, got %v", err)
+	}
+
+	// 停止时已排队的任务仍会执行
+	var n atomic.Int32
+	for range 6 {
+		if _, err = p.Submit(context.Background(), func(ctx context.Context) (any, error) {
+			time.Sleep(time.Millisecond)
+			n.Add(1)
+			return nil, nil
+		}); err != nil {
+			t.Fatalf("submit failed: %v", err)
+		}
+	}
+	p.StopAndWait()
+	if n.Load() != 6 {
+		t.Fatalf("queued tasks should finish before stop, finished %d", n.Load())
+	}
+	if _, err = p.Submit(context.Background(), func(ctx context.Context) (any, error) { return nil, nil }); !errors.Is(err, ErrPoolStopped) {
+		t.Fatalf("expected ErrPoolStopped, got %v", err)
+	}
+}
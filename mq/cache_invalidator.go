@@ -0,0 +1,74 @@
+package mq
+
+import (
+	"github.com/xyzj/toolbox"
+	"github.com/xyzj/toolbox/cache"
+	"github.com/xyzj/toolbox/json"
+)
+
+var _ cache.Invalidator = &CacheInvalidator{}
+
+// invalidation 缓存失效广播内容
+type invalidation struct {
+	Node string   `json:"node"`
+	Keys []string `json:"keys,omitempty"`
+}
+
+// CacheInvalidator 通过mqtt广播缓存失效消息，实现cache.Invalidator
+type CacheInvalidator struct {
+	cli    *MqttClientV5
+	topic  string
+	node   string
+	caches []interface{ Invalidate(keys ...string) }
+}
+
+// NewCacheInvalidator creates an invalidator that broadcasts the changed keys of the caches over the topic,
+// and drops the local copies of the caches when the broadcast of another node is received.
+// The topic must be subscribed in MqttOpt.Subscribe, and the receive callback of the client should call Handle.
+//
+// Example:
+//
+//	var inv *mq.CacheInvalidator
+//	opt.Subscribe = map[string]byte{"cache/invalidate": 1}
+//	cli, _ := mq.NewMqttClientV5(opt, func(topic string, body []byte) {
+//		if inv.Handle(topic, body) {
+//			return
+//		}
+//		// other topics
+//	})
+//	tc := cache.NewTieredCache[string](time.Minute, remote)
+//	inv = mq.NewCacheInvalidator(cli, "cache/invalidate", tc)
+//	tc.SetInvalidator(inv)
+func NewCacheInvalidator(cli *MqttClientV5, topic string, caches ...interface{ Invalidate(keys ...string) }) *CacheInvalidator {
+	return &CacheInvalidator{
+		cli:    cli,
+		topic:  topic,
+		node:   toolbox.GetRandomString(16, true),
+		caches: caches,
+	}
+}
+
+// Publish broadcasts the invalidation of the keys, an empty key list means all the keys.
+func (c *CacheInvalidator) Publish(keys ...string) error {
+	b, err := json.Marshal(&invalidation{Node: c.node, Keys: keys})
+	if err != nil {
+		return err
+	}
+	return c.cli.Write(c.topic, b, WithQos(1))
+}
+
+// Handle drops the local copies if the message is an invalidation broadcast of another node,
+// returns true if the message belongs to the invalidation topic.
+func (c *CacheInvalidator) Handle(topic string, body []byte) bool {
+	if c == nil || topic != c.topic {
+		return false
+	}
+	msg := &invalidation{}
+	if err := json.Unmarshal(body, msg); err != nil || msg.Node == c.node {
+		return true
+	}
+	for _, cc := range c.caches {
+		cc.Invalidate(msg.Keys...)
+	}
+	return true
+}
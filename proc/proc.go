@@ -71,6 +71,33 @@ type Recorder struct {
 	opt      *RecordOpt
 	locker   sync.Mutex
 	filename string
+	stats    map[string]func() any
+}
+
+// AddStats registers a named statistics source, such as the Stats method of a cache,
+// its current value is returned along with the process records by the POST handlers, see Stats.
+func (r *Recorder) AddStats(name string, f func() any) {
+	r.locker.Lock()
+	defer r.locker.Unlock()
+	if r.stats == nil {
+		r.stats = make(map[string]func() any)
+	}
+	r.stats[name] = f
+}
+
+// Stats returns the current values of the registered statistics sources
+func (r *Recorder) Stats() map[string]any {
+	r.locker.Lock()
+	fs := make(map[string]func() any, len(r.stats))
+	for k, f := range r.stats {
+		fs[k] = f
+	}
+	r.locker.Unlock()
+	x := make(map[string]any, len(fs))
+	for k, f := range fs {
+		x[k] = f()
+	}
+	return x
 }
 
 func (r *Recorder) LastHTML() string {
@@ -465,6 +492,7 @@ func (r *Recorder) GinHandler(c *gin.Context) {
 	switch c.Request.Method {
 	case "POST":
 		c.Set("data", js)
+		c.Set("stats", r.Stats())
 		c.Set("status", 1)
 		c.JSON(200, c.Keys)
 	case "GET":
@@ -480,6 +508,7 @@ func (r *Recorder) HTTPHandler(w http.ResponseWriter, req *http.Request) {
 	case "POST":
 		s, _ := sjson.SetBytes([]byte{}, "status", 1)
 		s, _ = sjson.SetBytes(s, "data", js)
+		s, _ = sjson.SetBytes(s, "stats", r.Stats())
 		w.WriteHeader(200)
 		w.Header().Set("Content-Type", "application/json")
 		w.Write(s)
@@ -0,0 +1,297 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// OverflowPolicy decides what a push does when the bounded Deque is full
+type OverflowPolicy byte
+
+const (
+	// OverflowBlock blocks the push until there is room, the push returns ErrTimeout if the context is done
+	OverflowBlock OverflowPolicy = iota
+	// OverflowDropOldest drops the element at the other end to make room,
+	// PushBack drops the front one and PushFront drops the back one
+	OverflowDropOldest
+	// OverflowReject returns ErrFull
+	OverflowReject
+)
+
+const dequeMinSize = 16
+
+// Deque is a generic double-ended queue backed by a ring buffer, safe for concurrent use.
+// It replaces the interface{} based Queue, and supports blocking pops, bounded capacity and batch draining.
+type Deque[T any] struct {
+	mutex    sync.Mutex
+	notEmpty *sync.Cond // 唤醒阻塞的 Pop
+	notFull  *sync.Cond // 唤醒阻塞的 Push
+	buf      []T
+	head     int // 第一个元素的位置
+	count    int
+	capacity int
+	policy   OverflowPolicy
+	zero     T
+	closed   bool
+}
+
+// NewDeque creates a deque.
+//
+// Parameters:
+//   - capacity: The maximum number of elements, not greater than 0 means unbounded.
+//   - policy: The overflow policy of the bounded deque.
+//
+// Example:
+//
+//	dq := queue.NewDeque[*Frame](1024, queue.OverflowDropOldest)
+//	dq.PushBack(frame)
+//	frame, err := dq.PopFrontWithContext(ctx)
+func NewDeque[T any](capacity int, policy OverflowPolicy) *Deque[T] {
+	capacity = max(capacity, 0)
+	size := dequeMinSize
+	if capacity > 0 {
+		size = min(capacity, size)
+	}
+	dq := &Deque[T]{
+		buf:      make([]T, size),
+		capacity: capacity,
+		policy:   policy,
+	}
+	dq.notEmpty = sync.NewCond(&dq.mutex)
+	dq.notFull = sync.NewCond(&dq.mutex)
+	return dq
+}
+
+// PushBack adds v to the back, see PushBackWithContext.
+func (dq *Deque[T]) PushBack(v T) error {
+	return dq.PushBackWithContext(context.TODO(), v)
+}
+
+// PushFront adds v to the front, see PushBackWithContext.
+func (dq *Deque[T]) PushFront(v T) error {
+	return dq.PushFrontWithContext(context.TODO(), v)
+}
+
+// PushBackWithContext adds v to the back. If the deque is full, the overflow policy decides whether it blocks
+// until there is room or the context is done, drops the front element, or returns ErrFull.
+// It returns ErrClosed if the deque is closed.
+func (dq *Deque[T]) PushBackWithContext(ctx context.Context, v T) error {
+	return dq.push(ctx, v, false)
+}
+
+// PushFrontWithContext adds v to the front, the same as PushBackWithContext except that
+// OverflowDropOldest drops the back element.
+func (dq *Deque[T]) PushFrontWithContext(ctx context.Context, v T) error {
+	return dq.push(ctx, v, true)
+}
+
+func (dq *Deque[T]) push(ctx context.Context, v T, front bool) error {
+	dq.mutex.Lock()
+	defer dq.mutex.Unlock()
+
+	if dq.closed {
+		return ErrClosed
+	}
+	if dq.full() {
+		switch dq.policy {
+		case OverflowReject:
+			return ErrFull
+		case OverflowDropOldest:
+			if front {
+				dq.popBackUnlocked()
+			} else {
+				dq.popFrontUnlocked()
+			}
+		default:
+			if err := dq.wait(ctx, dq.notFull, dq.full); err != nil {
+				return err
+			}
+		}
+	}
+
+	dq.grow()
+	if front {
+		dq.head = dq.index(-1)
+		dq.buf[dq.head] = v
+	} else {
+		dq.buf[dq.index(dq.count)] = v
+	}
+	dq.count++
+	dq.notEmpty.Signal()
+	return nil
+}
+
+// PopFront removes and returns the front element, it returns ErrEmpty if the deque is empty.
+func (dq *Deque[T]) PopFront() (T, error) {
+	dq.mutex.Lock()
+	defer dq.mutex.Unlock()
+	if dq.count == 0 {
+		return dq.zero, ErrEmpty
+	}
+	return dq.popFrontUnlocked(), nil
+}
+
+// PopBack removes and returns the back element, it returns ErrEmpty if the deque is empty.
+func (dq *Deque[T]) PopBack() (T, error) {
+	dq.mutex.Lock()
+	defer dq.mutex.Unlock()
+	if dq.count == 0 {
+		return dq.zero, ErrEmpty
+	}
+	return dq.popBackUnlocked(), nil
+}
+
+// PopFrontWithContext removes and returns the front element, it blocks until an element is available.
+// It returns ErrTimeout if the context is done, or ErrClosed once the deque is closed and drained.
+func (dq *Deque[T]) PopFrontWithContext(ctx context.Context) (T, error) {
+	return dq.pop(ctx, false)
+}
+
+// PopBackWithContext removes and returns the back element, it blocks like PopFrontWithContext.
+func (dq *Deque[T]) PopBackWithContext(ctx context.Context) (T, error) {
+	return dq.pop(ctx, true)
+}
+
+func (dq *Deque[T]) pop(ctx context.Context, back bool) (T, error) {
+	dq.mutex.Lock()
+	defer dq.mutex.Unlock()
+
+	if err := dq.wait(ctx, dq.notEmpty, func() bool { return dq.count == 0 }); err != nil {
+		return dq.zero, err
+	}
+	if back {
+		return dq.popBackUnlocked(), nil
+	}
+	return dq.popFrontUnlocked(), nil
+}
+
+// DrainN removes and returns up to n elements from the front in order without blocking,
+// n not greater than 0 means all the elements.
+func (dq *Deque[T]) DrainN(n int) []T {
+	dq.mutex.Lock()
+	defer dq.mutex.Unlock()
+
+	if n <= 0 || n > dq.count {
+		n = dq.count
+	}
+	x := make([]T, n)
+	for i := range x {
+		x[i] = dq.buf[dq.index(i)]
+		dq.buf[dq.index(i)] = dq.zero
+	}
+	dq.head = dq.index(n)
+	dq.count -= n
+	if n > 0 {
+		dq.notFull.Broadcast()
+	}
+	return x
+}
+
+// Len returns the number of elements
+func (dq *Deque[T]) Len() int {
+	dq.mutex.Lock()
+	defer dq.mutex.Unlock()
+	return dq.count
+}
+
+// Cap returns the capacity, 0 means unbounded
+func (dq *Deque[T]) Cap() int {
+	return dq.capacity
+}
+
+// Close rejects the pushes with ErrClosed and wakes up all the blocked calls,
+// the remaining elements can still be popped, after that the pops return ErrClosed.
+func (dq *Deque[T]) Close() {
+	dq.mutex.Lock()
+	defer dq.mutex.Unlock()
+	if dq.closed {
+		return
+	}
+	dq.closed = true
+	dq.notEmpty.Broadcast()
+	dq.notFull.Broadcast()
+}
+
+// IsClosed reports whether the deque has been closed
+func (dq *Deque[T]) IsClosed() bool {
+	dq.mutex.Lock()
+	defer dq.mutex.Unlock()
+	return dq.closed
+}
+
+// wait blocks on cond while blocked returns true, until the deque is closed or the context is done
+func (dq *Deque[T]) wait(ctx context.Context, cond *sync.Cond, blocked func() bool) error {
+	if !blocked() {
+		return nil
+	}
+
+	// ctx 取消时唤醒 cond.Wait
+	cancelWake := context.AfterFunc(ctx, func() {
+		dq.mutex.Lock()
+		cond.Broadcast()
+		dq.mutex.Unlock()
+	})
+	defer cancelWake()
+
+	for blocked() {
+		if dq.closed {
+			return ErrClosed
+		}
+		if err := ctx.Err(); err != nil {
+			if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+				return ErrTimeout
+			}
+			return err
+		}
+		cond.Wait()
+	}
+	// 等待期间关闭时，push 不再写入
+	if dq.closed && cond == dq.notFull {
+		return ErrClosed
+	}
+	return nil
+}
+
+func (dq *Deque[T]) full() bool {
+	return dq.capacity > 0 && dq.count >= dq.capacity
+}
+
+// index returns the position of the i-th element in the ring buffer, i may be -1
+func (dq *Deque[T]) index(i int) int {
+	return (dq.head + i + len(dq.buf)) % len(dq.buf)
+}
+
+// grow doubles the ring buffer if it is full, the elements are moved to the beginning
+func (dq *Deque[T]) grow() {
+	if dq.count < len(dq.buf) {
+		return
+	}
+	size := len(dq.buf) * 2
+	if dq.capacity > 0 {
+		size = min(size, dq.capacity)
+	}
+	buf := make([]T, size)
+	n := copy(buf, dq.buf[dq.head:])
+	copy(buf[n:], dq.buf[:dq.head])
+	dq.buf = buf
+	dq.head = 0
+}
+
+func (dq *Deque[T]) popFrontUnlocked() T {
+	v := dq.buf[dq.head]
+	dq.buf[dq.head] = dq.zero // 避免内存泄漏
+	dq.head = dq.index(1)
+	dq.count--
+	dq.notFull.Signal()
+	return v
+}
+
+func (dq *Deque[T]) popBackUnlocked() T {
+	i := dq.index(dq.count - 1)
+	v := dq.buf[i]
+	dq.buf[i] = dq.zero // 避免内存泄漏
+	dq.count--
+	dq.notFull.Signal()
+	return v
+}
@@ -0,0 +1,85 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDequePushPop(t *testing.T) {
+	dq := NewDeque[int](0, OverflowBlock)
+	for i := range 100 {
+		dq.PushBack(i)
+	}
+	dq.PushFront(-1)
+	if v, _ := dq.PopFront(); v != -1 {
+		t.Fatalf("unexpected front: %d", v)
+	}
+	if v, _ := dq.PopBack(); v != 99 {
+		t.Fatalf("unexpected back: %d", v)
+	}
+	if got := dq.DrainN(3); !reflect.DeepEqual(got, []int{0, 1, 2}) {
+		t.Fatalf("unexpected drain: %v", got)
+	}
+	if got := dq.DrainN(0); len(got) != 96 || got[0] != 3 || got[95] != 98 {
+		t.Fatalf("unexpected drain all: len=%d", len(got))
+	}
+	if _, err := dq.PopFront(); !errors.Is(err, ErrEmpty) {
+		t.Fatalf("expected ErrEmpty, got %v", err)
+	}
+}
+
+func TestDequeOverflow(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy OverflowPolicy
+		err    error
+		want   []int
+	}{
+		{name: "drop oldest", policy: OverflowDropOldest, want: []int{2, 3, 4}},
+		{name: "reject", policy: OverflowReject, err: ErrFull, want: []int{1, 2, 3}},
+		{name: "block", policy: OverflowBlock, err: ErrTimeout, want: []int{1, 2, 3}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dq := NewDeque[int](3, tt.policy)
+			for i := 1; i <= 3; i++ {
+				dq.PushBack(i)
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*20)
+			defer cancel()
+			if err := dq.PushBackWithContext(ctx, 4); !errors.Is(err, tt.err) {
+				t.Fatalf("unexpected error: got=%v want=%v", err, tt.err)
+			}
+			if got := dq.DrainN(0); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("unexpected elements: got=%v want=%v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDequeBlocking(t *testing.T) {
+	dq := NewDeque[string](1, OverflowBlock)
+	go func() {
+		time.Sleep(time.Millisecond * 10)
+		dq.PushBack("a")
+		dq.PushBack("b") // 阻塞到 a 被取出
+		dq.Close()
+	}()
+
+	for _, want := range []string{"a", "b"} {
+		got, err := dq.PopFrontWithContext(context.Background())
+		if err != nil || got != want {
+			t.Fatalf("unexpected pop: got=%q err=%v want=%q", got, err, want)
+		}
+	}
+	if _, err := dq.PopFrontWithContext(context.Background()); !errors.Is(err, ErrClosed) {
+		t.Fatalf("expected ErrClosed, got %v", err)
+	}
+	if err := dq.PushBack("c"); !errors.Is(err, ErrClosed) {
+		t.Fatalf("expected ErrClosed, got %v", err)
+	}
+}
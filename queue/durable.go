@@ -0,0 +1,440 @@
+package queue
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/xyzj/toolbox/json"
+	"go.etcd.io/bbolt"
+)
+
+// Serializer converts the payloads of DurableQueue to bytes and back, db.JSONSerializer and db.GobSerializer
+// can be used as well.
+type Serializer interface {
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+}
+
+// JSONSerializer serializes the payloads with the json package of toolbox
+type JSONSerializer struct{}
+
+func (JSONSerializer) Marshal(v any) ([]byte, error)      { return json.Marshal(v) }
+func (JSONSerializer) Unmarshal(data []byte, v any) error { return json.Unmarshal(data, v) }
+
+var (
+	bucketPending  = []byte("pending")  // 待消费的消息
+	bucketInflight = []byte("inflight") // 已取出但未确认的消息
+	bucketDead     = []byte("dead")     // 无法解析的消息
+)
+
+// durableItem 内存中的消息，key 为磁盘中的键
+type durableItem[T any] struct {
+	key     []byte
+	payload T
+}
+
+// Delivery is a message taken by Fetch, it stays on disk until Ack,
+// and is delivered again by Nack or after a restart if it is never acked.
+type Delivery[T any] struct {
+	Payload T
+
+	key  []byte
+	dq   *DurableQueue[T]
+	done atomic.Bool
+}
+
+// Ack removes the message from the disk, call it after the message is processed successfully.
+// Only the first call of Ack or Nack takes effect.
+func (d *Delivery[T]) Ack() error {
+	if !d.done.CompareAndSwap(false, true) {
+		return nil
+	}
+	return d.dq.ack(d.key)
+}
+
+// Nack puts the message back to the queue with its original priority and order, so it is delivered again.
+// Only the first call of Ack or Nack takes effect.
+func (d *Delivery[T]) Nack() error {
+	if !d.done.CompareAndSwap(false, true) {
+		return nil
+	}
+	return d.dq.nack(d.key, d.Payload)
+}
+
+// DurableQueue is a priority queue persisted in a bolt file, it has the same Put/GetWithContext API as PriorityQueue.
+// Every message is written to the disk before Put returns, the highest memLength messages are kept in memory as well,
+// the others are spilled to the disk only and loaded back when the memory is drained,
+// so a long outage of the consumer neither drops messages nor exhausts the memory.
+// The messages survive restarts, the ones fetched but not acked are delivered again after a restart.
+// A message that the serializer can not decode is moved to the "dead" bucket of the file instead of
+// blocking the queue, see DeadLetters.
+type DurableQueue[T any] struct {
+	db         *bbolt.DB
+	serializer Serializer
+	mutex      sync.Mutex
+	cond       *sync.Cond
+	mem        []*durableItem[T] // 磁盘中排在最前的消息，按key排序
+	memLength  int
+	spilled    int // 只在磁盘中的消息数量
+	inflight   int // 未确认的消息数量
+	maxLength  int
+	zero       T
+	closed     bool
+}
+
+// NewDurableQueue opens or creates the queue in the bolt file, the messages of the last run are restored.
+//
+// Parameters:
+//   - filename: The bolt file of the queue, it should not be shared with other BoltDB instances.
+//   - memLength: The maximum number of the messages kept in memory, at least 1.
+//   - maxLength: The maximum number of the messages not acked, Put returns ErrFull beyond it. 0 means unlimited.
+//   - serializer: The serializer of the payloads, nil means JSONSerializer.
+//
+// Return:
+//   - A pointer to the queue, or the error of opening the file.
+//
+// Example:
+//
+//	dq, err := queue.NewDurableQueue[*Report]("data/report.queue", 1000, 0, nil)
+//	dq.Put(queue.PriorityHigh, report)
+//	d, err := dq.Fetch(ctx)
+//	if err = send(d.Payload); err != nil {
+//		d.Nack()
+//	} else {
+//		d.Ack()
+//	}
+func NewDurableQueue[T any](filename string, memLength, maxLength int, serializer Serializer) (*DurableQueue[T], error) {
+	if dir := filepath.Dir(filename); dir != "." && dir != "" {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, err
+		}
+	}
+	bdb, err := bbolt.Open(filename, 0o640, &bbolt.Options{Timeout: time.Second * 2})
+	if err != nil {
+		return nil, err
+	}
+	if serializer == nil {
+		serializer = JSONSerializer{}
+	}
+	dq := &DurableQueue[T]{
+		db:         bdb,
+		serializer: serializer,
+		memLength:  max(memLength, 1),
+		maxLength:  max(maxLength, 0),
+	}
+	dq.cond = sync.NewCond(&dq.mutex)
+	if err = dq.restore(); err != nil {
+		bdb.Close()
+		return nil, err
+	}
+	return dq, nil
+}
+
+// restore moves the messages not acked back to the pending bucket and loads the head of the queue
+func (dq *DurableQueue[T]) restore() error {
+	err := dq.db.Update(func(tx *bbolt.Tx) error {
+		pending, err := tx.CreateBucketIfNotExists(bucketPending)
+		if err != nil {
+			return err
+		}
+		inflight, err := tx.CreateBucketIfNotExists(bucketInflight)
+		if err != nil {
+			return err
+		}
+		if err = inflight.ForEach(func(k, v []byte) error {
+			return pending.Put(k, v)
+		}); err != nil {
+			return err
+		}
+		if err = tx.DeleteBucket(bucketInflight); err != nil {
+			return err
+		}
+		if _, err = tx.CreateBucket(bucketInflight); err != nil {
+			return err
+		}
+		if _, err = tx.CreateBucketIfNotExists(bucketDead); err != nil {
+			return err
+		}
+		// Stats 不包含本事务中未提交的写入，用游标计数
+		n := 0
+		c := pending.Cursor()
+		for k, _ := c.First(); k != nil; k, _ = c.Next() {
+			n++
+		}
+		dq.spilled = n
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return dq.refillUnlocked()
+}
+
+// refillUnlocked loads the head of the pending bucket when the memory is drained,
+// the messages that can not be decoded are moved to the dead bucket
+func (dq *DurableQueue[T]) refillUnlocked() error {
+	if len(dq.mem) > 0 || dq.spilled <= 0 {
+		return nil
+	}
+	items := make([]*durableItem[T], 0, min(dq.spilled, dq.memLength))
+	dead := make([][]byte, 0)
+	err := dq.db.View(func(tx *bbolt.Tx) error {
+		c := tx.Bucket(bucketPending).Cursor()
+		for k, v := c.First(); k != nil && len(items) < dq.memLength; k, v = c.Next() {
+			var payload T
+			if err := dq.serializer.Unmarshal(v, &payload); err != nil {
+				dead = append(dead, slices.Clone(k))
+				continue
+			}
+			items = append(items, &durableItem[T]{key: slices.Clone(k), payload: payload})
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if len(dead) > 0 {
+		if err = dq.db.Update(func(tx *bbolt.Tx) error {
+			pending, bury := tx.Bucket(bucketPending), tx.Bucket(bucketDead)
+			for _, k := range dead {
+				if err := bury.Put(k, pending.Get(k)); err != nil {
+					return err
+				}
+				if err := pending.Delete(k); err != nil {
+					return err
+				}
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+	}
+	dq.mem = items
+	dq.spilled = max(dq.spilled-len(items)-len(dead), 0)
+	return nil
+}
+
+// keepUnlocked keeps the message in memory if it belongs to the head of the queue, otherwise it is spilled
+func (dq *DurableQueue[T]) keepUnlocked(item *durableItem[T]) {
+	n := len(dq.mem)
+	// 有消息只在磁盘中时，排在内存最后一条之后的消息不能放入内存，否则会越过磁盘中更靠前的消息
+	if dq.spilled > 0 && (n == 0 || bytes.Compare(item.key, dq.mem[n-1].key) > 0) {
+		dq.spilled++
+		return
+	}
+	idx := sort.Search(n, func(i int) bool { return bytes.Compare(dq.mem[i].key, item.key) > 0 })
+	dq.mem = slices.Insert(dq.mem, idx, item)
+	if len(dq.mem) > dq.memLength {
+		dq.mem[len(dq.mem)-1] = nil
+		dq.mem = dq.mem[:len(dq.mem)-1]
+		dq.spilled++
+	}
+}
+
+// Put writes the message to the disk and puts it into the queue.
+// It returns ErrFull if there are maxLength messages not acked.
+func (dq *DurableQueue[T]) Put(priority Priority, payload T) error {
+	b, err := dq.serializer.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	dq.mutex.Lock()
+	defer dq.mutex.Unlock()
+	if dq.closed {
+		return ErrClosed
+	}
+	if dq.maxLength > 0 && len(dq.mem)+dq.spilled+dq.inflight >= dq.maxLength {
+		return ErrFull
+	}
+	var key []byte
+	err = dq.db.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(bucketPending)
+		seq, err := bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		// 优先级取反后在前，同优先级按写入顺序，磁盘中的键顺序即出队顺序
+		key = binary.BigEndian.AppendUint64([]byte{^byte(priority)}, seq)
+		return bucket.Put(key, b)
+	})
+	if err != nil {
+		return err
+	}
+	dq.keepUnlocked(&durableItem[T]{key: key, payload: payload})
+	dq.cond.Signal()
+	return nil
+}
+
+// Get takes the highest priority message and removes it from the disk, it blocks if the queue is empty.
+func (dq *DurableQueue[T]) Get() (T, error) {
+	return dq.GetWithContext(context.TODO())
+}
+
+// GetWithContext takes the highest priority message and removes it from the disk,
+// it blocks until a message is available, the queue is closed or the context is done.
+// Use Fetch if the message should be removed only after it is processed.
+func (dq *DurableQueue[T]) GetWithContext(ctx context.Context) (T, error) {
+	item, err := dq.take(ctx, false)
+	if err != nil {
+		return dq.zero, err
+	}
+	return item.payload, nil
+}
+
+// Fetch takes the highest priority message like GetWithContext, but the message stays on disk until it is acked.
+func (dq *DurableQueue[T]) Fetch(ctx context.Context) (*Delivery[T], error) {
+	item, err := dq.take(ctx, true)
+	if err != nil {
+		return nil, err
+	}
+	return &Delivery[T]{Payload: item.payload, key: item.key, dq: dq}, nil
+}
+
+func (dq *DurableQueue[T]) take(ctx context.Context, keep bool) (*durableItem[T], error) {
+	dq.mutex.Lock()
+	defer dq.mutex.Unlock()
+
+	if dq.closed {
+		return nil, ErrClosed
+	}
+
+	// ctx 取消时唤醒 cond.Wait
+	cancelWake := context.AfterFunc(ctx, func() {
+		dq.mutex.Lock()
+		dq.cond.Broadcast()
+		dq.mutex.Unlock()
+	})
+	defer cancelWake()
+
+	for {
+		if err := dq.refillUnlocked(); err != nil {
+			return nil, err
+		}
+		if len(dq.mem) > 0 {
+			item := dq.mem[0]
+			err := dq.db.Update(func(tx *bbolt.Tx) error {
+				pending := tx.Bucket(bucketPending)
+				if keep {
+					if err := tx.Bucket(bucketInflight).Put(item.key, pending.Get(item.key)); err != nil {
+						return err
+					}
+				}
+				return pending.Delete(item.key)
+			})
+			if err != nil {
+				return nil, err
+			}
+			dq.mem[0] = nil
+			dq.mem = dq.mem[1:]
+			if keep {
+				dq.inflight++
+			}
+			return item, nil
+		}
+		if dq.closed {
+			return nil, ErrClosed
+		}
+		if err := ctx.Err(); err != nil {
+			if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+				return nil, ErrTimeout
+			}
+			return nil, err
+		}
+		dq.cond.Wait()
+	}
+}
+
+func (dq *DurableQueue[T]) ack(key []byte) error {
+	dq.mutex.Lock()
+	defer dq.mutex.Unlock()
+	if dq.closed {
+		return ErrClosed
+	}
+	if err := dq.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(bucketInflight).Delete(key)
+	}); err != nil {
+		return err
+	}
+	dq.inflight--
+	return nil
+}
+
+func (dq *DurableQueue[T]) nack(key []byte, payload T) error {
+	dq.mutex.Lock()
+	defer dq.mutex.Unlock()
+	if dq.closed {
+		return ErrClosed
+	}
+	if err := dq.db.Update(func(tx *bbolt.Tx) error {
+		inflight := tx.Bucket(bucketInflight)
+		if err := tx.Bucket(bucketPending).Put(key, inflight.Get(key)); err != nil {
+			return err
+		}
+		return inflight.Delete(key)
+	}); err != nil {
+		return err
+	}
+	dq.inflight--
+	dq.keepUnlocked(&durableItem[T]{key: key, payload: payload})
+	dq.cond.Signal()
+	return nil
+}
+
+// Len returns the number of the messages waiting in the queue, including the ones spilled to the disk
+func (dq *DurableQueue[T]) Len() int {
+	dq.mutex.Lock()
+	defer dq.mutex.Unlock()
+	return len(dq.mem) + dq.spilled
+}
+
+// DeadLetters returns the number of the messages moved to the dead bucket because they could not be decoded,
+// they are kept in the file for inspection and never delivered
+func (dq *DurableQueue[T]) DeadLetters() int {
+	dq.mutex.Lock()
+	defer dq.mutex.Unlock()
+	if dq.closed {
+		return 0
+	}
+	n := 0
+	dq.db.View(func(tx *bbolt.Tx) error {
+		n = tx.Bucket(bucketDead).Stats().KeyN
+		return nil
+	})
+	return n
+}
+
+// Unacked returns the number of the messages fetched but not acked
+func (dq *DurableQueue[T]) Unacked() int {
+	dq.mutex.Lock()
+	defer dq.mutex.Unlock()
+	return dq.inflight
+}
+
+// Close closes the bolt file and wakes up all the blocked Get calls.
+// Unlike PriorityQueue, the messages are kept on the disk and restored by the next NewDurableQueue,
+// the messages not acked are delivered again.
+func (dq *DurableQueue[T]) Close() error {
+	dq.mutex.Lock()
+	defer dq.mutex.Unlock()
+	if dq.closed {
+		return nil
+	}
+	dq.closed = true
+	dq.mem = nil
+	dq.cond.Broadcast()
+	return dq.db.Close()
+}
+
+// IsClosed reports whether the queue has been closed
+func (dq *DurableQueue[T]) IsClosed() bool {
+	return dq.closed
+}
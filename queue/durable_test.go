@@ -0,0 +1,238 @@
+package queue
+
+import (
+	"context"
+	"encoding/binary"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+func openDurable(t *testing.T, filename string, memLength, maxLength int) *DurableQueue[int] {
+	t.Helper()
+	dq, err := NewDurableQueue[int](filename, memLength, maxLength, nil)
+	if err != nil {
+		t.Fatalf("open queue failed: %v", err)
+	}
+	return dq
+}
+
+func fetch(t *testing.T, dq *DurableQueue[int]) *Delivery[int] {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer cancel()
+	d, err := dq.Fetch(ctx)
+	if err != nil {
+		t.Fatalf("fetch failed: %v", err)
+	}
+	return d
+}
+
+func TestDurableQueueRestart(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "q.db")
+	dq := openDurable(t, filename, 3, 0)
+	for i := range 10 {
+		if err := dq.Put(PriorityNormal, i); err != nil {
+			t.Fatalf("put failed: %v", err)
+		}
+	}
+	// 取出5条，确认2条，其余3条未确认
+	for i := range 5 {
+		d := fetch(t, dq)
+		if d.Payload != i {
+			t.Fatalf("unexpected message: got=%d want=%d", d.Payload, i)
+		}
+		if i < 2 {
+			if err := d.Ack(); err != nil {
+				t.Fatalf("ack failed: %v", err)
+			}
+		}
+	}
+	if dq.Len() != 5 || dq.Unacked() != 3 {
+		t.Fatalf("unexpected len=%d unacked=%d", dq.Len(), dq.Unacked())
+	}
+	dq.Close()
+
+	// 重启后未确认的消息重新投递，并按原来的顺序排在前面
+	dq = openDurable(t, filename, 3, 0)
+	defer dq.Close()
+	if dq.Len() != 8 || dq.Unacked() != 0 {
+		t.Fatalf("unexpected len=%d unacked=%d after restart", dq.Len(), dq.Unacked())
+	}
+	for i := 2; i < 10; i++ {
+		got, err := dq.Get()
+		if err != nil || got != i {
+			t.Fatalf("unexpected message: got=%d want=%d err=%v", got, i, err)
+		}
+	}
+	if dq.Len() != 0 {
+		t.Fatalf("expect empty, len=%d", dq.Len())
+	}
+}
+
+func TestDurableQueueRestartUnacked(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "q.db")
+	dq := openDurable(t, filename, 2, 0)
+	for i := range 10 {
+		dq.Put(PriorityNormal, i)
+	}
+	for range 5 {
+		fetch(t, dq)
+	}
+	dq.Close()
+
+	// 全部未确认的消息和待消费的消息都要计入，内存容量小于消息数量时也能逐批载入
+	dq = openDurable(t, filename, 2, 0)
+	defer dq.Close()
+	if dq.Len() != 10 {
+		t.Fatalf("expect 10 messages after restart, got %d", dq.Len())
+	}
+	for i := range 10 {
+		d := fetch(t, dq)
+		if d.Payload != i {
+			t.Fatalf("unexpected message: got=%d want=%d", d.Payload, i)
+		}
+		d.Ack()
+	}
+	if dq.Len() != 0 || dq.Unacked() != 0 {
+		t.Fatalf("unexpected len=%d unacked=%d", dq.Len(), dq.Unacked())
+	}
+}
+
+func TestDurableQueueNack(t *testing.T) {
+	dq := openDurable(t, filepath.Join(t.TempDir(), "q.db"), 10, 0)
+	defer dq.Close()
+	dq.Put(PriorityLow, 3)
+	dq.Put(PriorityNormal, 1)
+	dq.Put(PriorityNormal, 2)
+	dq.Put(PriorityHigh, 0)
+
+	first, second := fetch(t, dq), fetch(t, dq)
+	if first.Payload != 0 || second.Payload != 1 {
+		t.Fatalf("unexpected messages: %d %d", first.Payload, second.Payload)
+	}
+	// 退回的消息保持原来的优先级和顺序
+	if err := second.Nack(); err != nil {
+		t.Fatalf("nack failed: %v", err)
+	}
+	if err := first.Nack(); err != nil {
+		t.Fatalf("nack failed: %v", err)
+	}
+	// 重复确认无效
+	if err := first.Ack(); err != nil || dq.Unacked() != 0 {
+		t.Fatalf("expect the second call ignored, unacked=%d err=%v", dq.Unacked(), err)
+	}
+	for i := range 4 {
+		if got, _ := dq.Get(); got != i {
+			t.Fatalf("unexpected message: got=%d want=%d", got, i)
+		}
+	}
+}
+
+func TestDurableQueueSpill(t *testing.T) {
+	dq := openDurable(t, filepath.Join(t.TempDir(), "q.db"), 4, 0)
+	defer dq.Close()
+	for i := range 20 {
+		dq.Put(PriorityNormal, i+100)
+	}
+	if len(dq.mem) != 4 || dq.spilled != 16 {
+		t.Fatalf("expect 4 in memory and 16 spilled, got %d %d", len(dq.mem), dq.spilled)
+	}
+	// 高优先级消息进入内存，挤出的消息溢出到磁盘
+	dq.Put(PriorityHigh, 0)
+	if len(dq.mem) != 4 || dq.spilled != 17 {
+		t.Fatalf("expect 4 in memory and 17 spilled, got %d %d", len(dq.mem), dq.spilled)
+	}
+	want := []int{0}
+	for i := range 20 {
+		want = append(want, i+100)
+	}
+	for _, w := range want {
+		if got, err := dq.Get(); err != nil || got != w {
+			t.Fatalf("unexpected message: got=%d want=%d err=%v", got, w, err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+	if _, err := dq.GetWithContext(ctx); err != ErrTimeout {
+		t.Fatalf("expect ErrTimeout on empty queue, got %v", err)
+	}
+}
+
+func TestDurableQueueFull(t *testing.T) {
+	dq := openDurable(t, filepath.Join(t.TempDir(), "q.db"), 2, 3)
+	defer dq.Close()
+	for i := range 3 {
+		if err := dq.Put(PriorityNormal, i); err != nil {
+			t.Fatalf("put failed: %v", err)
+		}
+	}
+	if err := dq.Put(PriorityNormal, 3); err != ErrFull {
+		t.Fatalf("expect ErrFull, got %v", err)
+	}
+	// 未确认的消息仍然占用容量
+	d := fetch(t, dq)
+	if err := dq.Put(PriorityNormal, 3); err != ErrFull {
+		t.Fatalf("expect ErrFull with an unacked message, got %v", err)
+	}
+	d.Ack()
+	if err := dq.Put(PriorityNormal, 3); err != nil {
+		t.Fatalf("expect put after ack, got %v", err)
+	}
+
+	dq.Close()
+	if err := dq.Put(PriorityNormal, 4); err != ErrClosed {
+		t.Fatalf("expect ErrClosed, got %v", err)
+	}
+}
+
+func TestDurableQueueDeadLetter(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "q.db")
+	dq := openDurable(t, filename, 2, 0)
+	for i := range 4 {
+		dq.Put(PriorityNormal, i)
+	}
+	dq.Close()
+
+	// 在队首写入无法解析的消息
+	bdb, err := bbolt.Open(filename, 0o640, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bdb.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(bucketPending)
+		for i := range 3 {
+			key := binary.BigEndian.AppendUint64([]byte{^byte(PriorityHigh)}, uint64(i))
+			if err := b.Put(key, []byte("broken")); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	bdb.Close()
+
+	// 无法解析的消息移入死信，不阻塞后面的消息
+	dq = openDurable(t, filename, 2, 0)
+	if got, err := dq.Get(); err != nil || got != 0 {
+		t.Fatalf("unexpected message: got=%d err=%v", got, err)
+	}
+	if n := dq.DeadLetters(); n != 3 || dq.Len() != 3 {
+		t.Fatalf("expect 3 dead letters and 3 messages, got %d %d", n, dq.Len())
+	}
+	dq.Close()
+
+	// 重启后死信仍然保留，不会再次投递
+	dq = openDurable(t, filename, 2, 0)
+	defer dq.Close()
+	if n := dq.DeadLetters(); n != 3 || dq.Len() != 3 {
+		t.Fatalf("expect 3 dead letters and 3 messages after restart, got %d %d", n, dq.Len())
+	}
+	for want := 1; want < 4; want++ {
+		if got, err := dq.Get(); err != nil || got != want {
+			t.Fatalf("unexpected message: got=%d want=%d err=%v", got, want, err)
+		}
+	}
+}
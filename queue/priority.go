@@ -24,7 +24,9 @@ const (
 type messageItem[T any] struct {
 	Priority  Priority  // 优先级：数字越大，优先级越高 (例如，9 > 1)
 	CreatedAt time.Time // 消息插入时间，用于同优先级下的 FIFO 排序
+	DueAt     time.Time // 延迟消息的到期时间，到期前不可见，非延迟消息为零值
 	Payload   T         // 实际消息内容
+	rank      int64     // 调度排序值，见 scheduler
 }
 
 // --- 2. 核心：优先级堆实现 (实现 heap.Interface 接口) ---
@@ -38,6 +40,10 @@ func (h priorityHeap[T]) Len() int { return len(h) }
 
 // 堆接口要求的 Less()：定义排序规则（优先级越高，时间越早，越排在前面）
 func (h priorityHeap[T]) Less(i, j int) bool {
+	// 规则 0: 启用老化或加权公平时，按调度排序值
+	if h[i].rank != h[j].rank {
+		return h[i].rank < h[j].rank
+	}
 	// 规则 1: 优先级高的排在前面 (Priority 大的在前面)
 	if h[i].Priority != h[j].Priority {
 		return h[i].Priority > h[j].Priority // 核心：反转 Less()，实现最大堆行为
@@ -65,11 +71,25 @@ func (h *priorityHeap[T]) Pop() any {
 	return item
 }
 
+// delayHeap 未到期的延迟消息，按到期时间排序，同时到期的按优先级排序
+type delayHeap[T any] struct {
+	priorityHeap[T]
+}
+
+func (h delayHeap[T]) Less(i, j int) bool {
+	if !h.priorityHeap[i].DueAt.Equal(h.priorityHeap[j].DueAt) {
+		return h.priorityHeap[i].DueAt.Before(h.priorityHeap[j].DueAt)
+	}
+	return h.priorityHeap.Less(i, j)
+}
+
 // --- 3. 阻塞队列包装器 ---
 
 // PriorityQueue 包装器，包含堆、锁、条件变量和最大长度
 type PriorityQueue[T any] struct {
 	heap      *priorityHeap[T]
+	delayed   *delayHeap[T] // 未到期的延迟消息
+	sched     scheduler     // 老化或加权公平调度
 	mutex     sync.RWMutex
 	cond      *sync.Cond // 用于阻塞/唤醒 Get 操作
 	maxLength int
@@ -82,6 +102,7 @@ func NewPriorityQueue[T any](maxLength int) *PriorityQueue[T] {
 	h := &priorityHeap[T]{}
 	pq := &PriorityQueue[T]{
 		heap:      h,
+		delayed:   &delayHeap[T]{},
 		maxLength: maxLength,
 	}
 	pq.cond = sync.NewCond(&pq.mutex) // 条件变量必须基于 Mutex
@@ -91,19 +112,44 @@ func NewPriorityQueue[T any](maxLength int) *PriorityQueue[T] {
 
 // Put 将消息放入队列。如果队列已满，则返回错误。
 func (pq *PriorityQueue[T]) Put(priority Priority, payload T) error {
+	return pq.PutAt(priority, payload, time.Time{})
+}
+
+// PutAt puts the message into the queue, the message becomes visible to Get only at the due time.
+// In the strict priority order, the due messages are taken by the due time and then the priority,
+// before the messages put without delay. With SetAging or SetWeightedFair, a due message is scheduled
+// like a message put at its due time.
+// A due time not after now means the message is visible immediately, the same as Put.
+// The delayed messages count towards maxLength.
+//
+// Example:
+//
+//	// 30秒后向设备下发命令
+//	pq.PutAt(queue.PriorityNormal, cmd, time.Now().Add(time.Second*30))
+func (pq *PriorityQueue[T]) PutAt(priority Priority, payload T, due time.Time) error {
 	pq.mutex.Lock()
 	defer pq.mutex.Unlock()
 	if pq.closed { // 检查是否已关闭
 		return ErrClosed
 	}
-	if pq.heap.Len() >= pq.maxLength {
+	if pq.lenUnlocked() >= pq.maxLength {
 		return ErrFull
 	}
+	now := time.Now()
 	msg := &messageItem[T]{
 		Priority:  priority,
-		CreatedAt: time.Now(),
+		CreatedAt: now,
+		DueAt:     due,
 		Payload:   payload,
 	}
+	if due.After(now) {
+		heap.Push(pq.delayed, msg)
+		// 到期时间可能早于等待者的定时器，全部唤醒以重新计算
+		pq.cond.Broadcast()
+		return nil
+	}
+	msg.DueAt = time.Time{}
+	msg.rank = pq.sched.rank(priority, now)
 	heap.Push(pq.heap, msg)
 
 	// 唤醒一个可能阻塞在 Get 上的 Goroutine
@@ -111,6 +157,31 @@ func (pq *PriorityQueue[T]) Put(priority Priority, payload T) error {
 	return nil
 }
 
+// PutAfter puts the message into the queue, the message becomes visible to Get after the delay, see PutAt.
+func (pq *PriorityQueue[T]) PutAfter(priority Priority, payload T, delay time.Duration) error {
+	return pq.PutAt(priority, payload, time.Now().Add(delay))
+}
+
+// promoteUnlocked makes the due messages visible in the order of the due time, returns the due time of
+// the next delayed message, or false if there is none
+func (pq *PriorityQueue[T]) promoteUnlocked() (time.Time, bool) {
+	now := time.Now()
+	for pq.delayed.Len() > 0 {
+		next := pq.delayed.priorityHeap[0]
+		if next.DueAt.After(now) {
+			return next.DueAt, true
+		}
+		heap.Pop(pq.delayed)
+		next.rank = pq.sched.rankOf(next.Priority, next.CreatedAt, next.DueAt)
+		heap.Push(pq.heap, next)
+	}
+	return time.Time{}, false
+}
+
+func (pq *PriorityQueue[T]) lenUnlocked() int {
+	return pq.heap.Len() + pq.delayed.Len()
+}
+
 // Get 从队列中取出优先级最高的消息。如果队列为空，则阻塞。
 func (pq *PriorityQueue[T]) Get() (T, error) {
 	return pq.GetWithContext(context.TODO())
@@ -136,10 +207,22 @@ func (pq *PriorityQueue[T]) GetWithContext(ctx context.Context) (T, error) {
 	})
 	defer cancelWake()
 
+	// 下一条延迟消息到期时唤醒
+	var timer *time.Timer
+	defer func() {
+		if timer != nil {
+			timer.Stop()
+		}
+	}()
+
 	for {
+		// 到期的延迟消息进入堆，与其他消息一起调度
+		next, delayed := pq.promoteUnlocked()
 		// 有元素直接取
 		if pq.heap.Len() > 0 {
-			return heap.Pop(pq.heap).(*messageItem[T]).Payload, nil
+			item := heap.Pop(pq.heap).(*messageItem[T])
+			pq.sched.served(item.rank)
+			return item.Payload, nil
 		}
 		// 队列已关闭
 		if pq.closed {
@@ -152,16 +235,28 @@ func (pq *PriorityQueue[T]) GetWithContext(ctx context.Context) (T, error) {
 			}
 			return pq.zero, err
 		}
-		// 等待被 Put 或 Cancel 唤醒
+		if delayed {
+			d := time.Until(next)
+			if timer == nil {
+				timer = time.AfterFunc(d, func() {
+					pq.mutex.Lock()
+					pq.cond.Broadcast()
+					pq.mutex.Unlock()
+				})
+			} else {
+				timer.Reset(d)
+			}
+		}
+		// 等待被 Put、Cancel 或延迟消息到期唤醒
 		pq.cond.Wait()
 	}
 }
 
-// Length 返回当前队列中的元素数量
+// Length 返回当前队列中的元素数量，包括未到期的延迟消息
 func (pq *PriorityQueue[T]) Len() int {
 	pq.mutex.RLock()
 	defer pq.mutex.RUnlock()
-	return pq.heap.Len()
+	return pq.lenUnlocked()
 }
 
 // Close 清理队列内容，并唤醒所有阻塞的 GetContext 请求。
@@ -177,10 +272,48 @@ func (pq *PriorityQueue[T]) Close() {
 	// 清理队列内容（可选，但通常在关闭时执行）
 	pq.heap = &priorityHeap[T]{}
 	heap.Init(pq.heap)
+	pq.delayed = &delayHeap[T]{}
 	// 唤醒所有等待在 cond.Wait() 上的 Goroutines，它们将在 GetContext 中检查 closed 状态后返回 nil
 	pq.cond.Broadcast()
 }
 
+// moveLocker 串行化 MoveTo，使其可以同时持有两个队列的锁而不会死锁
+var moveLocker sync.Mutex
+
+// MoveTo moves the messages to dst, keeping their priority, insertion time and due time.
+// Visible messages are moved in priority order before the delayed ones, the ones that do not fit into dst
+// remain in the queue, see Len.
+// It returns the number of messages moved, or ErrClosed without moving anything if either queue is closed.
+func (pq *PriorityQueue[T]) MoveTo(dst *PriorityQueue[T]) (int, error) {
+	if pq == dst {
+		return 0, nil
+	}
+	moveLocker.Lock()
+	defer moveLocker.Unlock()
+	pq.mutex.Lock()
+	defer pq.mutex.Unlock()
+	dst.mutex.Lock()
+	defer dst.mutex.Unlock()
+	if pq.closed || dst.closed {
+		return 0, ErrClosed
+	}
+	n := 0
+	for pq.heap.Len() > 0 && dst.lenUnlocked() < dst.maxLength {
+		item := heap.Pop(pq.heap).(*messageItem[T])
+		item.rank = dst.sched.rankOf(item.Priority, item.CreatedAt, item.DueAt)
+		heap.Push(dst.heap, item)
+		n++
+	}
+	for pq.delayed.Len() > 0 && dst.lenUnlocked() < dst.maxLength {
+		heap.Push(dst.delayed, heap.Pop(pq.delayed))
+		n++
+	}
+	if n > 0 {
+		dst.cond.Broadcast()
+	}
+	return n, nil
+}
+
 // IsClosed reports whether the priority queue has been closed.
 // It returns true if the internal closed flag is set, false otherwise.
 // The check is performed atomically and is safe for concurrent use.
@@ -214,5 +347,6 @@ func (pq *PriorityQueue[T]) Reset() error {
 	}
 	pq.heap = &priorityHeap[T]{}
 	heap.Init(pq.heap)
+	pq.delayed = &delayHeap[T]{}
 	return nil
 }
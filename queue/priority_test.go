@@ -0,0 +1,250 @@
+package queue
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestPriorityQueueAging(t *testing.T) {
+	pq := NewPriorityQueue[string](100)
+	pq.SetAging(time.Millisecond * 5)
+	if err := pq.Put(PriorityLowest, "low"); err != nil {
+		t.Fatalf("put failed: %v", err)
+	}
+	// 等待超过 (PriorityHighest-PriorityLowest)*step 后，低优先级消息应排在新的高优先级消息之前
+	time.Sleep(time.Millisecond * 60)
+	for range 50 {
+		if err := pq.Put(PriorityHighest, "high"); err != nil {
+			t.Fatalf("put failed: %v", err)
+		}
+	}
+	if got, _ := pq.Get(); got != "low" {
+		t.Fatalf("aged message should be taken first, got=%s", got)
+	}
+
+	// 关闭老化后恢复严格优先级
+	pq.SetAging(0)
+	pq.Put(PriorityLowest, "low")
+	for range 50 {
+		if got, _ := pq.Get(); got != "high" {
+			t.Fatalf("strict priority expected, got=%s", got)
+		}
+	}
+	if got, _ := pq.Get(); got != "low" {
+		t.Fatalf("unexpected message: %s", got)
+	}
+}
+
+func TestPriorityQueueAgingBoundedWait(t *testing.T) {
+	step := time.Millisecond * 2
+	pq := NewPriorityQueue[string](1000)
+	pq.SetAging(step)
+	for range 100 {
+		pq.Put(PriorityHighest, "high")
+	}
+	start := time.Now()
+	pq.Put(PriorityLowest, "low")
+
+	// 持续有高优先级消息写入，低优先级消息的等待时间仍然有上限
+	bound := step * time.Duration(PriorityHighest-PriorityLowest)
+	deadline := start.Add(time.Second)
+	for time.Now().Before(deadline) {
+		got, err := pq.Get()
+		if err != nil {
+			t.Fatalf("get failed: %v", err)
+		}
+		if got == "low" {
+			if wait := time.Since(start); wait < bound {
+				t.Fatalf("low message taken too early: %v < %v", wait, bound)
+			}
+			return
+		}
+		pq.Put(PriorityHighest, "high")
+	}
+	t.Fatalf("low message starved for %v, expected about %v", time.Since(start), bound)
+}
+
+func TestPriorityQueueWeightedFair(t *testing.T) {
+	tests := []struct {
+		name    string
+		weights map[Priority]int
+		every   int // 每隔多少条取出一条低优先级消息
+	}{
+		{name: "default weights", weights: map[Priority]int{}, every: 10},
+		{name: "custom weights", weights: map[Priority]int{PriorityHighest: 3, PriorityLowest: 1}, every: 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pq := NewPriorityQueue[int](1000)
+			pq.SetWeightedFair(tt.weights)
+			for i := range 100 {
+				pq.Put(PriorityHighest, i)
+			}
+			for i := range 10 {
+				pq.Put(PriorityLowest, -i-1)
+			}
+
+			lows := 0
+			for i := 1; i <= tt.every*5; i++ {
+				got, err := pq.Get()
+				if err != nil {
+					t.Fatalf("get failed: %v", err)
+				}
+				isLow := got < 0
+				if isLow != (i%tt.every == 0) {
+					t.Fatalf("unexpected message at %d: %d", i, got)
+				}
+				if isLow {
+					lows++
+					// 同一优先级内仍按写入顺序
+					if got != -lows {
+						t.Fatalf("low messages out of order: got=%d want=%d", got, -lows)
+					}
+				}
+			}
+
+			pq.SetWeightedFair(nil)
+			if got, _ := pq.Get(); got < 0 {
+				t.Fatalf("strict priority expected after disabling, got=%d", got)
+			}
+		})
+	}
+}
+
+func TestPriorityQueueMoveTo(t *testing.T) {
+	src := NewPriorityQueue[int](10)
+	for i := range 5 {
+		src.Put(PriorityNormal, i)
+	}
+	src.PutAfter(PriorityNormal, 5, time.Hour)
+
+	// 目标队列已关闭时不移动任何消息
+	closed := NewPriorityQueue[int](10)
+	closed.Close()
+	if n, err := src.MoveTo(closed); n != 0 || err != ErrClosed {
+		t.Fatalf("expect ErrClosed, got %d %v", n, err)
+	}
+	if src.Len() != 6 {
+		t.Fatalf("messages lost moving to a closed queue, len=%d", src.Len())
+	}
+
+	// 放不下的消息保留在源队列
+	dst := NewPriorityQueue[int](3)
+	if n, err := src.MoveTo(dst); n != 3 || err != nil {
+		t.Fatalf("expect 3 moved, got %d %v", n, err)
+	}
+	if src.Len() != 3 || dst.Len() != 3 {
+		t.Fatalf("unexpected len: src=%d dst=%d", src.Len(), dst.Len())
+	}
+	for i := range 3 {
+		if got, _ := dst.Get(); got != i {
+			t.Fatalf("unexpected message: got=%d want=%d", got, i)
+		}
+	}
+	if n, err := src.MoveTo(dst); n != 3 || err != nil || src.Len() != 0 {
+		t.Fatalf("expect the rest moved, got %d %v len=%d", n, err, src.Len())
+	}
+	for i := 3; i < 5; i++ {
+		if got, _ := dst.Get(); got != i {
+			t.Fatalf("unexpected message: got=%d want=%d", got, i)
+		}
+	}
+	if dst.Len() != 1 {
+		t.Fatalf("expect the delayed message kept, len=%d", dst.Len())
+	}
+}
+
+func TestPriorityQueueDelayWakeUp(t *testing.T) {
+	pq := NewPriorityQueue[string](10)
+	pq.PutAfter(PriorityNormal, "late", time.Second)
+	start := time.Now()
+	go func() {
+		// 阻塞等待期间写入更早到期的消息，等待者应按新的到期时间唤醒
+		time.Sleep(time.Millisecond * 20)
+		pq.PutAfter(PriorityNormal, "early", time.Millisecond*100)
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*2)
+	defer cancel()
+	got, err := pq.GetWithContext(ctx)
+	wait := time.Since(start)
+	if err != nil || got != "early" {
+		t.Fatalf("unexpected message: %s %v", got, err)
+	}
+	if wait < time.Millisecond*110 || wait > time.Millisecond*400 {
+		t.Fatalf("expect woken up at maturity, waited %v", wait)
+	}
+
+	// 未到期时超时返回
+	ctx2, cancel2 := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel2()
+	if _, err = pq.GetWithContext(ctx2); err != ErrTimeout {
+		t.Fatalf("expect ErrTimeout before maturity, got %v", err)
+	}
+}
+
+func TestPriorityQueueDelayOrder(t *testing.T) {
+	pq := NewPriorityQueue[string](10)
+	due := time.Now().Add(time.Millisecond * 50)
+	pq.PutAt(PriorityLow, "due2-low", due.Add(time.Millisecond))
+	pq.PutAt(PriorityLow, "due1-low", due)
+	pq.PutAt(PriorityHighest, "due2-high", due.Add(time.Millisecond))
+	pq.PutAt(PriorityHigh, "due1-high", due)
+	pq.Put(PriorityHighest, "now")
+	time.Sleep(time.Millisecond * 80)
+
+	// 到期的消息按到期时间、再按优先级排序，并排在立即可见的消息之前
+	for _, want := range []string{"due1-high", "due1-low", "due2-high", "due2-low", "now"} {
+		if got, _ := pq.Get(); got != want {
+			t.Fatalf("unexpected message: got=%s want=%s", got, want)
+		}
+	}
+}
+
+func TestPriorityQueuePutAfterNow(t *testing.T) {
+	pq := NewPriorityQueue[string](10)
+	pq.Put(PriorityLow, "low")
+	// 零或负的延迟与 Put 相同，立即可见并按优先级排序
+	pq.PutAfter(PriorityHigh, "zero", 0)
+	pq.PutAfter(PriorityHighest, "past", -time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+	defer cancel()
+	for _, want := range []string{"past", "zero", "low"} {
+		if got, err := pq.GetWithContext(ctx); err != nil || got != want {
+			t.Fatalf("unexpected message: got=%s want=%s err=%v", got, want, err)
+		}
+	}
+}
+
+func TestPriorityQueueDelaySchedule(t *testing.T) {
+	// 老化：到期的延迟消息按到期时间计算等待时间，不会越过等待更久的消息
+	pq := NewPriorityQueue[string](100)
+	pq.SetAging(time.Millisecond)
+	pq.Put(PriorityLowest, "low")
+	pq.PutAfter(PriorityHighest, "due", time.Millisecond*50)
+	time.Sleep(time.Millisecond * 60)
+	for _, want := range []string{"low", "due"} {
+		if got, _ := pq.Get(); got != want {
+			t.Fatalf("aging: got=%s want=%s", got, want)
+		}
+	}
+
+	// 加权公平：持续到期的高优先级延迟消息不会饿死已在队列中的低优先级消息
+	pq = NewPriorityQueue[string](100)
+	pq.SetWeightedFair(map[Priority]int{PriorityHigh: 1, PriorityLow: 1})
+	for range 5 {
+		pq.Put(PriorityLow, "low")
+		pq.PutAfter(PriorityHigh, "due", time.Millisecond*20)
+	}
+	time.Sleep(time.Millisecond * 30)
+	low := 0
+	for range 4 {
+		if got, _ := pq.Get(); got == "low" {
+			low++
+		}
+	}
+	if low != 2 {
+		t.Fatalf("weighted fair: expect 2 low messages of the first 4, got %d", low)
+	}
+}
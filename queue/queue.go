@@ -8,6 +8,8 @@ import (
 )
 
 // Queue queue for go
+//
+// Deprecated: use Deque, which is typed and supports blocking pops and bounded capacity
 type Queue struct {
 	c      atomic.Int32
 	q      *list.List
@@ -0,0 +1,104 @@
+package queue
+
+import (
+	"container/heap"
+	"math"
+	"sort"
+	"time"
+)
+
+// fairScale 加权公平模式下权重为1的消息占用的虚拟时间
+const fairScale = 1 << 20
+
+// scheduler 出队调度策略，为消息计算排序值 rank，rank 越小越先出队，未启用时 rank 均为 0
+type scheduler struct {
+	aging   time.Duration      // 老化：等待 aging 时间相当于提升一级优先级
+	weights map[Priority]int   // 加权公平：各优先级的权重，nil 表示未启用
+	vtime   int64              // 加权公平：虚拟时间，即最近出队消息的 rank
+	finish  map[Priority]int64 // 加权公平：各优先级最后一条消息的 rank
+}
+
+// rank returns the order of the message, the smaller the earlier
+func (s *scheduler) rank(priority Priority, created time.Time) int64 {
+	switch {
+	case s.aging > 0:
+		// 等价于按等待时间持续提升优先级，两条消息的先后与当前时间无关，堆的有序性不会被破坏
+		return created.UnixNano() - int64(priority)*int64(s.aging)
+	case s.weights != nil:
+		w, ok := s.weights[priority]
+		if !ok {
+			w = int(priority)
+		}
+		r := max(s.vtime, s.finish[priority]) + fairScale/int64(max(w, 1))
+		s.finish[priority] = r
+		return r
+	}
+	return 0
+}
+
+// rankOf returns the order of a visible message, a delayed message is ranked as if it was put at its due time.
+// In the strict priority order, the due delayed messages are taken by the due time before the others.
+func (s *scheduler) rankOf(priority Priority, created, due time.Time) int64 {
+	switch {
+	case due.IsZero():
+		return s.rank(priority, created)
+	case s.aging > 0 || s.weights != nil:
+		return s.rank(priority, due)
+	}
+	return math.MinInt64 + due.UnixNano()
+}
+
+// served advances the virtual time to the message taken
+func (s *scheduler) served(rank int64) {
+	if s.weights != nil {
+		s.vtime = rank
+	}
+}
+
+// rescheduleUnlocked recalculates the order of the visible messages after the policy is changed
+func (pq *PriorityQueue[T]) rescheduleUnlocked() {
+	pq.sched.vtime = 0
+	pq.sched.finish = make(map[Priority]int64)
+	items := *pq.heap
+	sort.Slice(items, items.Less)
+	for _, item := range items {
+		item.rank = pq.sched.rankOf(item.Priority, item.CreatedAt, item.DueAt)
+	}
+	heap.Init(pq.heap)
+}
+
+// SetAging enables priority aging to prevent starvation: a message gains one priority level for every step it waits,
+// so a low priority message waits at most (PriorityHighest - its priority) * step behind the newer higher ones.
+// A step not greater than 0 disables aging and restores the strict priority order.
+// Aging replaces the weighted-fair mode.
+//
+// Example:
+//
+//	pq.SetAging(time.Second) // a PriorityLowest message is taken at most 8 seconds later than a new PriorityHighest one
+func (pq *PriorityQueue[T]) SetAging(step time.Duration) {
+	pq.mutex.Lock()
+	defer pq.mutex.Unlock()
+	pq.sched.aging = max(step, 0)
+	pq.sched.weights = nil
+	pq.rescheduleUnlocked()
+}
+
+// SetWeightedFair enables the weighted-fair mode: the priority levels share the dequeues in proportion to their weights,
+// e.g. with the weights 9 and 1, one of every 10 messages taken is a low one when both levels are backlogged,
+// and the messages of the same level are still taken in order.
+// A priority missing in the weights has the weight of its own value, so an empty map gives PriorityHighest
+// 9 times the share of PriorityLowest. Nil disables the mode and restores the strict priority order.
+// The weighted-fair mode replaces aging.
+func (pq *PriorityQueue[T]) SetWeightedFair(weights map[Priority]int) {
+	pq.mutex.Lock()
+	defer pq.mutex.Unlock()
+	pq.sched.aging = 0
+	pq.sched.weights = nil
+	if weights != nil {
+		pq.sched.weights = make(map[Priority]int, len(weights))
+		for p, w := range weights {
+			pq.sched.weights[p] = w
+		}
+	}
+	pq.rescheduleUnlocked()
+}
@@ -0,0 +1,119 @@
+package storage
+
+import (
+	"encoding/binary"
+	"errors"
+
+	"github.com/xyzj/toolbox/db"
+	"go.etcd.io/bbolt"
+)
+
+// Bolt is a bolt implementation of Storage, each namespace is a bucket of the bolt file,
+// so several histories can share one file.
+// The records are keyed by the big-endian sequence of the bucket, the keys are ordered as the records are stored,
+// and the oldest records are deleted in the same transaction of Store once there are more than maxLines records.
+type Bolt struct {
+	db       *db.BoltDB
+	bucket   []byte
+	maxLines int
+}
+
+// NewBolt creates a storage in the namespace bucket of the bolt file, an empty namespace means "history".
+//
+// Example:
+//
+//	bdb, _ := db.NewBolt("data/history.db")
+//	chat := storage.NewBolt(bdb, "chat", 1000)
+//	audit := storage.NewBolt(bdb, "audit", 10000)
+func NewBolt(bdb *db.BoltDB, namespace string, maxLines int) *Bolt {
+	if maxLines < 0 {
+		maxLines = 0
+	}
+	if namespace == "" {
+		namespace = "history"
+	}
+	return &Bolt{
+		db:       bdb,
+		bucket:   []byte(namespace),
+		maxLines: maxLines,
+	}
+}
+
+func (s *Bolt) Store(history ...string) error {
+	if len(history) == 0 {
+		return nil
+	}
+	if s.maxLines == 0 {
+		return s.Clear()
+	}
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(s.bucket)
+		if err != nil {
+			return err
+		}
+		// 只写入最终会保留的记录
+		if over := len(history) - s.maxLines; over > 0 {
+			history = history[over:]
+		}
+		for _, line := range history {
+			seq, err := b.NextSequence()
+			if err != nil {
+				return err
+			}
+			if err = b.Put(boltKey(seq), []byte(line)); err != nil {
+				return err
+			}
+		}
+		return s.trim(b)
+	})
+}
+
+func (s *Bolt) Load() ([]string, error) {
+	lines := make([]string, 0, 32)
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(s.bucket)
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(_, v []byte) error {
+			lines = append(lines, string(v))
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return lines, nil
+}
+
+func (s *Bolt) Clear() error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		err := tx.DeleteBucket(s.bucket)
+		if errors.Is(err, bbolt.ErrBucketNotFound) {
+			return nil
+		}
+		return err
+	})
+}
+
+// trim deletes the oldest records of the bucket beyond maxLines.
+// Only the oldest records are deleted, so the keys are contiguous and the count is last - first + 1.
+func (s *Bolt) trim(b *bbolt.Bucket) error {
+	c := b.Cursor()
+	first, _ := c.First()
+	last, _ := c.Last()
+	if first == nil {
+		return nil
+	}
+	from, to := binary.BigEndian.Uint64(first), binary.BigEndian.Uint64(last)
+	for seq := from; to-seq+1 > uint64(s.maxLines); seq++ {
+		if err := b.Delete(boltKey(seq)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func boltKey(seq uint64) []byte {
+	return binary.BigEndian.AppendUint64(make([]byte, 0, 8), seq)
+}
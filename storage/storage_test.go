@@ -1,8 +1,13 @@
 package storage
 
 import (
+	"os"
+	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
+
+	"github.com/xyzj/toolbox/db"
 )
 
 func TestMemoryStoreEvictOldest(t *testing.T) {
@@ -44,6 +49,8 @@ func TestStorageClear(t *testing.T) {
 	}{
 		{name: "memory", new: func() Storage { return NewMemory(4) }},
 		{name: "ring", new: func() Storage { return NewRing(4) }},
+		{name: "wal", new: func() Storage { return newTestWAL(t, t.TempDir(), 4) }},
+		{name: "bolt", new: func() Storage { return NewBolt(newTestBolt(t), "test", 4) }},
 	}
 
 	for _, tt := range tests {
@@ -74,6 +81,8 @@ func TestStorageLoadReturnsCopy(t *testing.T) {
 	}{
 		{name: "memory", new: func() Storage { return NewMemory(4) }},
 		{name: "ring", new: func() Storage { return NewRing(4) }},
+		{name: "wal", new: func() Storage { return newTestWAL(t, t.TempDir(), 4) }},
+		{name: "bolt", new: func() Storage { return NewBolt(newTestBolt(t), "test", 4) }},
 	}
 
 	for _, tt := range tests {
@@ -105,3 +114,181 @@ func TestStorageLoadReturnsCopy(t *testing.T) {
 		})
 	}
 }
+
+func newTestWAL(t *testing.T, dir string, maxLines int) *WAL {
+	t.Helper()
+	w, err := NewWAL(dir, maxLines, SyncAlways)
+	if err != nil {
+		t.Fatalf("open wal failed: %v", err)
+	}
+	t.Cleanup(func() { _ = w.Close() })
+	return w
+}
+
+func TestWALTrimSegments(t *testing.T) {
+	dir := t.TempDir()
+	w := newTestWAL(t, dir, 100)
+	payload := benchmarkPayload(1000)
+	for i := 0; i < len(payload); i += 10 {
+		if err := w.Store(payload[i : i+10]...); err != nil {
+			t.Fatalf("store failed: %v", err)
+		}
+	}
+
+	got, err := w.Load()
+	if err != nil {
+		t.Fatalf("load failed: %v", err)
+	}
+	if !reflect.DeepEqual(got, payload[900:]) {
+		t.Fatalf("unexpected history: got %d lines, first=%v", len(got), got[:1])
+	}
+	segments, _ := filepath.Glob(filepath.Join(dir, "*"+walSuffix))
+	if len(segments) > 3 {
+		t.Fatalf("old segments should be deleted, got %d segments", len(segments))
+	}
+}
+
+func TestWALBatchSegment(t *testing.T) {
+	w := newTestWAL(t, t.TempDir(), 100)
+	payload := benchmarkPayload(70)
+	// 活动分段放不下整批记录时先切换分段，一批记录不会被拆开
+	for _, batch := range [][]string{payload[:60], payload[60:]} {
+		if err := w.Store(batch...); err != nil {
+			t.Fatalf("store failed: %v", err)
+		}
+	}
+	lines := make([]int, 0, len(w.segments))
+	for _, seg := range w.segments {
+		lines = append(lines, seg.lines)
+	}
+	if !reflect.DeepEqual(lines, []int{60, 10}) {
+		t.Fatalf("unexpected segment lines: %v", lines)
+	}
+	if got, _ := w.Load(); !reflect.DeepEqual(got, payload) {
+		t.Fatalf("unexpected history: got %d lines", len(got))
+	}
+}
+
+func TestWALRecoverTornTail(t *testing.T) {
+	dir := t.TempDir()
+	w, err := NewWAL(dir, 10, SyncNever)
+	if err != nil {
+		t.Fatalf("open wal failed: %v", err)
+	}
+	if err = w.Store("a", "b", "c"); err != nil {
+		t.Fatalf("store failed: %v", err)
+	}
+	_ = w.Close()
+
+	// 模拟崩溃：最后一条记录只写了一半，之后又有一条校验错误的记录
+	path := w.segmentPath(1)
+	st, _ := os.Stat(path)
+	if err = os.Truncate(path, st.Size()-1); err != nil {
+		t.Fatal(err)
+	}
+	fd, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o644)
+	rec := appendRecord(nil, "d")
+	rec[len(rec)-1] = 'x'
+	_, _ = fd.Write(rec)
+	_ = fd.Close()
+
+	w = newTestWAL(t, dir, 10)
+	got, err := w.Load()
+	if err != nil {
+		t.Fatalf("load failed: %v", err)
+	}
+	if !reflect.DeepEqual(got, []string{"a", "b"}) {
+		t.Fatalf("unexpected history after recovery: %v", got)
+	}
+	if err = w.Store("e"); err != nil {
+		t.Fatalf("store failed: %v", err)
+	}
+	w.Close()
+
+	w = newTestWAL(t, dir, 10)
+	got, _ = w.Load()
+	if !reflect.DeepEqual(got, []string{"a", "b", "e"}) {
+		t.Fatalf("records appended after recovery should be readable: %v", got)
+	}
+}
+
+func TestWALStoreFailure(t *testing.T) {
+	dir := t.TempDir()
+	w := newTestWAL(t, dir, 10)
+	if err := w.Store("a", "b"); err != nil {
+		t.Fatalf("store failed: %v", err)
+	}
+
+	// 超长记录整批拒绝
+	if err := w.Store("c", strings.Repeat("x", walMaxRecordSize+1)); err != ErrWALRecordTooLarge {
+		t.Fatalf("expect ErrWALRecordTooLarge, got %v", err)
+	}
+
+	// 模拟写入失败：活动分段无法写入也无法截断，失败的记录不计数，之后的记录写入新分段
+	active := w.active
+	ro, err := os.Open(w.segmentPath(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.active = ro
+	if err = w.Store("c", "d"); err == nil {
+		t.Fatal("expect write error")
+	}
+	active.Close()
+	if got, _ := w.Load(); !reflect.DeepEqual(got, []string{"a", "b"}) {
+		t.Fatalf("failed records should not be counted: %v", got)
+	}
+	if err = w.Store("e"); err != nil {
+		t.Fatalf("store after failure failed: %v", err)
+	}
+	w.Close()
+
+	w = newTestWAL(t, dir, 10)
+	if got, _ := w.Load(); !reflect.DeepEqual(got, []string{"a", "b", "e"}) {
+		t.Fatalf("unexpected history after reopen: %v", got)
+	}
+}
+
+func newTestBolt(t *testing.T) *db.BoltDB {
+	t.Helper()
+	bdb, err := db.NewBolt(filepath.Join(t.TempDir(), "storage.db"))
+	if err != nil {
+		t.Fatalf("open bolt failed: %v", err)
+	}
+	t.Cleanup(func() { _ = bdb.Close() })
+	return bdb
+}
+
+func TestBoltNamespaces(t *testing.T) {
+	bdb := newTestBolt(t)
+	a := NewBolt(bdb, "a", 3)
+	b := NewBolt(bdb, "b", 3)
+	if err := a.Store("a1", "a2"); err != nil {
+		t.Fatalf("store failed: %v", err)
+	}
+	if err := a.Store("a3", "a4", "a5"); err != nil {
+		t.Fatalf("store failed: %v", err)
+	}
+	if err := b.Store("b1", "b2", "b3", "b4", "b5"); err != nil {
+		t.Fatalf("store failed: %v", err)
+	}
+	if err := b.Store("b6"); err != nil {
+		t.Fatalf("store failed: %v", err)
+	}
+
+	got, _ := a.Load()
+	if want := []string{"a3", "a4", "a5"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected history: got=%v want=%v", got, want)
+	}
+	got, _ = b.Load()
+	if want := []string{"b4", "b5", "b6"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected history: got=%v want=%v", got, want)
+	}
+
+	if err := a.Clear(); err != nil {
+		t.Fatalf("clear failed: %v", err)
+	}
+	if got, _ = b.Load(); len(got) != 3 {
+		t.Fatalf("clear should not touch other namespaces, got=%v", got)
+	}
+}
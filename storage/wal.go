@@ -0,0 +1,403 @@
+package storage
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	walSuffix          = ".wal"
+	walHeaderSize      = 8 // 4字节长度 + 4字节crc
+	walMaxRecordSize   = 64 << 20
+	walMinSegmentLines = 64
+	walSyncInterval    = time.Second
+)
+
+// SyncPolicy decides when the WAL calls fsync
+type SyncPolicy byte
+
+const (
+	// SyncNever leaves the data to the os page cache, the latest records may be lost if the os crashes
+	SyncNever SyncPolicy = iota
+	// SyncInterval calls fsync once a second if there are new records
+	SyncInterval
+	// SyncAlways calls fsync before Store returns
+	SyncAlways
+)
+
+var (
+	// ErrWALClosed is returned when the WAL is used after Close
+	ErrWALClosed = errors.New("wal is closed")
+	// ErrWALRecordTooLarge is returned by Store when a record exceeds the 64MB limit, nothing is written
+	ErrWALRecordTooLarge = errors.New("wal record too large")
+
+	walTable = crc32.MakeTable(crc32.Castagnoli)
+)
+
+type walSegment struct {
+	id    uint64
+	lines int
+}
+
+// WAL is a segmented append-only log implementation of Storage.
+// Each record is stored with its length and crc32 checksum, records are appended to the active segment,
+// a new segment is created when the active one is full, and the oldest segments are deleted
+// once the newer ones hold maxLines records, so the log is never rewritten.
+// The records of one Store call are written to the same segment, a failed Store leaves none of them,
+// so a segment holds more records than usual when a large batch is stored.
+// A torn or corrupted record left by a crash is truncated when the WAL is opened.
+type WAL struct {
+	mu           sync.Mutex
+	dir          string
+	maxLines     int
+	segmentLines int
+	policy       SyncPolicy
+
+	segments []*walSegment
+	active   *os.File
+	size     int64 // 活动分段的有效长度
+	total    int
+	dirty    bool
+	closed   bool
+	done     chan struct{}
+}
+
+// NewWAL opens the WAL in dir, creates dir if it does not exist, and recovers the existing segments.
+// Load returns the latest maxLines records, each segment holds a quarter of maxLines records.
+func NewWAL(dir string, maxLines int, policy SyncPolicy) (*WAL, error) {
+	if maxLines < 0 {
+		maxLines = 0
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	w := &WAL{
+		dir:          dir,
+		maxLines:     maxLines,
+		segmentLines: max(maxLines/4, walMinSegmentLines),
+		policy:       policy,
+		done:         make(chan struct{}),
+	}
+	if err := w.recover(); err != nil {
+		return nil, err
+	}
+	if err := w.openActiveUnlocked(); err != nil {
+		return nil, err
+	}
+
+	if policy == SyncInterval {
+		go w.runSyncLoop()
+	}
+
+	return w, nil
+}
+
+func (w *WAL) Store(history ...string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return ErrWALClosed
+	}
+	if len(history) == 0 || w.maxLines == 0 {
+		return nil
+	}
+	for _, line := range history {
+		if len(line) > walMaxRecordSize {
+			return ErrWALRecordTooLarge
+		}
+	}
+
+	// 整批写入同一分段，分段放不下时先切换，避免一批记录被分到两个分段而写入失败时只留下一部分
+	if lines := w.segments[len(w.segments)-1].lines; lines > 0 && lines+len(history) > w.segmentLines {
+		if err := w.rotateUnlocked(); err != nil {
+			return err
+		}
+	}
+	buf := make([]byte, 0, 256)
+	for _, line := range history {
+		buf = appendRecord(buf, line)
+	}
+	if err := w.writeUnlocked(buf, len(history)); err != nil {
+		return err
+	}
+
+	return w.trimUnlocked()
+}
+
+func (w *WAL) Load() ([]string, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return nil, ErrWALClosed
+	}
+
+	skip := max(w.total-w.maxLines, 0)
+	lines := make([]string, 0, w.total-skip)
+	for _, seg := range w.segments {
+		if skip >= seg.lines {
+			skip -= seg.lines
+			continue
+		}
+		_, err := readSegment(w.segmentPath(seg.id), func(data []byte) {
+			if skip > 0 {
+				skip--
+				return
+			}
+			lines = append(lines, string(data))
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return lines, nil
+}
+
+func (w *WAL) Clear() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return ErrWALClosed
+	}
+	if err := w.active.Close(); err != nil {
+		return err
+	}
+	next := w.segments[len(w.segments)-1].id + 1
+	for _, seg := range w.segments {
+		if err := os.Remove(w.segmentPath(seg.id)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	w.segments = []*walSegment{{id: next}}
+	w.total = 0
+	w.dirty = false
+	return w.openActiveUnlocked()
+}
+
+// Close syncs and closes the active segment, the WAL can not be used after Close.
+func (w *WAL) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	close(w.done)
+	if err := w.active.Sync(); err != nil {
+		_ = w.active.Close()
+		return err
+	}
+	return w.active.Close()
+}
+
+func (w *WAL) runSyncLoop() {
+	ticker := time.NewTicker(walSyncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.done:
+			return
+		case <-ticker.C:
+			w.mu.Lock()
+			if w.dirty && !w.closed {
+				if err := w.active.Sync(); err == nil {
+					w.dirty = false
+				}
+			}
+			w.mu.Unlock()
+		}
+	}
+}
+
+func (w *WAL) segmentPath(id uint64) string {
+	return filepath.Join(w.dir, fmt.Sprintf("%016d%s", id, walSuffix))
+}
+
+// recover loads the existing segments and truncates the torn or corrupted tail of each segment
+func (w *WAL) recover() error {
+	entries, err := os.ReadDir(w.dir)
+	if err != nil {
+		return err
+	}
+	ids := make([]uint64, 0, len(entries))
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(name, walSuffix) {
+			continue
+		}
+		id, err := strconv.ParseUint(strings.TrimSuffix(name, walSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	for _, id := range ids {
+		seg := &walSegment{id: id}
+		valid, err := readSegment(w.segmentPath(id), func([]byte) { seg.lines++ })
+		if err != nil {
+			return err
+		}
+		if err = truncateSegment(w.segmentPath(id), valid); err != nil {
+			return err
+		}
+		w.segments = append(w.segments, seg)
+		w.total += seg.lines
+	}
+	if len(w.segments) == 0 {
+		w.segments = append(w.segments, &walSegment{id: 1})
+	}
+	return w.trimUnlocked()
+}
+
+func (w *WAL) openActiveUnlocked() error {
+	fd, err := os.OpenFile(w.segmentPath(w.segments[len(w.segments)-1].id), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	fi, err := fd.Stat()
+	if err != nil {
+		fd.Close()
+		return err
+	}
+	w.active = fd
+	w.size = fi.Size()
+	return nil
+}
+
+// writeUnlocked appends the n records in buf to the active segment.
+// On failure the segment is truncated back to the last valid record, so the records are either all written or not at all.
+func (w *WAL) writeUnlocked(buf []byte, n int) error {
+	if len(buf) == 0 {
+		return nil
+	}
+	_, err := w.active.Write(buf)
+	if err == nil && w.policy == SyncAlways {
+		err = w.active.Sync()
+	}
+	if err != nil {
+		return w.discardUnlocked(err)
+	}
+	w.size += int64(len(buf))
+	w.segments[len(w.segments)-1].lines += n
+	w.total += n
+	w.dirty = w.policy != SyncAlways
+	return nil
+}
+
+// discardUnlocked drops the partial write after the last valid record and returns cause.
+// If the segment can not be truncated, it is sealed and a new segment is started,
+// the torn tail is truncated when the WAL is opened again.
+func (w *WAL) discardUnlocked(cause error) error {
+	if err := w.active.Truncate(w.size); err == nil {
+		return cause
+	}
+	_ = w.active.Close()
+	w.segments = append(w.segments, &walSegment{id: w.segments[len(w.segments)-1].id + 1})
+	w.dirty = false
+	if err := w.openActiveUnlocked(); err != nil {
+		return errors.Join(cause, err)
+	}
+	return cause
+}
+
+// rotateUnlocked seals the active segment and starts a new one
+func (w *WAL) rotateUnlocked() error {
+	if w.policy != SyncNever {
+		if err := w.active.Sync(); err != nil {
+			return err
+		}
+	}
+	if err := w.active.Close(); err != nil {
+		return err
+	}
+	w.segments = append(w.segments, &walSegment{id: w.segments[len(w.segments)-1].id + 1})
+	w.dirty = false
+	return w.openActiveUnlocked()
+}
+
+// trimUnlocked deletes the oldest segments that are not needed to hold maxLines records
+func (w *WAL) trimUnlocked() error {
+	for len(w.segments) > 1 && w.total-w.segments[0].lines >= w.maxLines {
+		if err := os.Remove(w.segmentPath(w.segments[0].id)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		w.total -= w.segments[0].lines
+		w.segments = w.segments[1:]
+	}
+	return nil
+}
+
+func appendRecord(buf []byte, line string) []byte {
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(len(line)))
+	buf = binary.LittleEndian.AppendUint32(buf, crc32.Checksum([]byte(line), walTable))
+	return append(buf, line...)
+}
+
+// readSegment calls f with each valid record, returns the size of the valid part of the segment
+func readSegment(path string, f func(data []byte)) (int64, error) {
+	fd, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	defer fd.Close()
+
+	r := bufio.NewReader(fd)
+	header := make([]byte, walHeaderSize)
+	var valid int64
+	for {
+		if _, err = io.ReadFull(r, header); err != nil {
+			break
+		}
+		size := binary.LittleEndian.Uint32(header)
+		if size > walMaxRecordSize {
+			break
+		}
+		data := make([]byte, size)
+		if _, err = io.ReadFull(r, data); err != nil {
+			break
+		}
+		if crc32.Checksum(data, walTable) != binary.LittleEndian.Uint32(header[4:]) {
+			break
+		}
+		f(data)
+		valid += walHeaderSize + int64(size)
+	}
+	// 文件结尾不完整的记录视为崩溃时未写完的数据
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return valid, err
+	}
+	return valid, nil
+}
+
+func truncateSegment(path string, size int64) error {
+	st, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if st.Size() == size {
+		return nil
+	}
+	return os.Truncate(path, size)
+}
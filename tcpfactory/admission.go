@@ -0,0 +1,135 @@
+package tcpfactory
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	rejectDenied      = "ip denied"
+	rejectNotAllowed  = "ip not allowed"
+	rejectRate        = "accept rate exceeded"
+	rejectMaxConns    = "too many connections"
+	rejectMaxConnsPer = "too many connections from ip"
+)
+
+// admission 连接准入控制
+type admission struct {
+	locker   sync.Mutex
+	allow    []*net.IPNet
+	deny     []*net.IPNet
+	maxConns int
+	maxPerIP int
+	perIP    map[string]int
+	total    int
+	rate     float64 // 每秒允许接入的连接数
+	tokens   float64
+	last     time.Time
+}
+
+func newAdmission(o *opt) (*admission, error) {
+	allow, err := parseCIDRs(o.allowCIDRs)
+	if err != nil {
+		return nil, err
+	}
+	deny, err := parseCIDRs(o.denyCIDRs)
+	if err != nil {
+		return nil, err
+	}
+	return &admission{
+		allow:    allow,
+		deny:     deny,
+		maxConns: int(o.maxConns),
+		maxPerIP: int(o.maxConnsPerIP),
+		perIP:    make(map[string]int),
+		rate:     float64(o.acceptRate),
+		tokens:   float64(o.acceptRate),
+		last:     time.Now(),
+	}, nil
+}
+
+// parseCIDRs parses the cidr list, a single ip is treated as a /32 or /128 network
+func parseCIDRs(ss []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(ss))
+	for _, s := range ss {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		if !strings.Contains(s, "/") {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid cidr: %s", s)
+			}
+			if ip.To4() != nil {
+				s += "/32"
+			} else {
+				s += "/128"
+			}
+		}
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cidr: %s", s)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+func contains(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// admit checks whether a new connection from ip is accepted, returns the reject reason if not.
+// The accepted connection must be released by release.
+func (a *admission) admit(ip net.IP) (bool, string) {
+	if contains(a.deny, ip) {
+		return false, rejectDenied
+	}
+	if len(a.allow) > 0 && !contains(a.allow, ip) {
+		return false, rejectNotAllowed
+	}
+	a.locker.Lock()
+	defer a.locker.Unlock()
+	if a.maxConns > 0 && a.total >= a.maxConns {
+		return false, rejectMaxConns
+	}
+	key := ip.String()
+	if a.maxPerIP > 0 && a.perIP[key] >= a.maxPerIP {
+		return false, rejectMaxConnsPer
+	}
+	// 最后消耗令牌，被其他规则拒绝的连接不占用接入速率
+	if a.rate > 0 {
+		now := time.Now()
+		a.tokens = min(a.tokens+now.Sub(a.last).Seconds()*a.rate, max(a.rate, 1))
+		a.last = now
+		if a.tokens < 1 {
+			return false, rejectRate
+		}
+		a.tokens--
+	}
+	a.total++
+	a.perIP[key]++
+	return true, ""
+}
+
+// release releases an accepted connection
+func (a *admission) release(ip net.IP) {
+	key := ip.String()
+	a.locker.Lock()
+	a.total--
+	if a.perIP[key] <= 1 {
+		delete(a.perIP, key)
+	} else {
+		a.perIP[key]--
+	}
+	a.locker.Unlock()
+}
@@ -0,0 +1,80 @@
+package tcpfactory
+
+import (
+	"net"
+	"testing"
+)
+
+func TestAdmission(t *testing.T) {
+	o := defaultOpt
+	WithAllowCIDRs("10.0.0.0/8", "192.168.1.1")(&o)
+	WithDenyCIDRs("10.1.0.0/16")(&o)
+	WithMaxConnections(3)(&o)
+	WithMaxConnectionsPerIP(2)(&o)
+	a, err := newAdmission(&o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		ip     string
+		reason string
+	}{
+		{"10.1.2.3", rejectDenied},
+		{"172.16.0.1", rejectNotAllowed},
+		{"10.0.0.1", ""},
+		{"10.0.0.1", ""},
+		{"10.0.0.1", rejectMaxConnsPer},
+		{"192.168.1.1", ""},
+		{"10.0.0.2", rejectMaxConns},
+	}
+	for _, c := range cases {
+		ok, reason := a.admit(net.ParseIP(c.ip))
+		if ok != (c.reason == "") || reason != c.reason {
+			t.Fatalf("%s: expect %q, got %v %q", c.ip, c.reason, ok, reason)
+		}
+	}
+	a.release(net.ParseIP("10.0.0.1"))
+	if ok, reason := a.admit(net.ParseIP("10.0.0.2")); !ok {
+		t.Fatalf("expect accepted after release, got %q", reason)
+	}
+
+	WithDenyCIDRs("not a cidr")(&o)
+	if _, err = newAdmission(&o); err == nil {
+		t.Fatal("expect invalid cidr error")
+	}
+}
+
+func TestAdmissionRate(t *testing.T) {
+	o := defaultOpt
+	WithAcceptRateLimit(2)(&o)
+	a, _ := newAdmission(&o)
+	ip := net.ParseIP("127.0.0.1")
+	for i := 0; i < 2; i++ {
+		if ok, reason := a.admit(ip); !ok {
+			t.Fatalf("expect accepted, got %q", reason)
+		}
+	}
+	if ok, reason := a.admit(ip); ok || reason != rejectRate {
+		t.Fatalf("expect rate limited, got %v %q", ok, reason)
+	}
+}
+
+func TestAdmissionRateAfterLimits(t *testing.T) {
+	o := defaultOpt
+	WithAcceptRateLimit(2)(&o)
+	WithMaxConnectionsPerIP(1)(&o)
+	a, _ := newAdmission(&o)
+	busy, idle := net.ParseIP("10.0.0.1"), net.ParseIP("10.0.0.2")
+	if ok, reason := a.admit(busy); !ok {
+		t.Fatalf("expect accepted, got %q", reason)
+	}
+	// 被连接数限制拒绝的连接不应消耗令牌
+	for i := 0; i < 5; i++ {
+		if ok, reason := a.admit(busy); ok || reason != rejectMaxConnsPer {
+			t.Fatalf("expect per ip limited, got %v %q", ok, reason)
+		}
+	}
+	if ok, reason := a.admit(idle); !ok {
+		t.Fatalf("expect accepted, got %q", reason)
+	}
+}
@@ -0,0 +1,236 @@
+package tcpfactory
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xyzj/toolbox/json"
+	"github.com/xyzj/toolbox/logger"
+	"github.com/xyzj/toolbox/queue"
+)
+
+const (
+	captureIn  = "in"
+	captureOut = "out"
+)
+
+// CaptureRecord is one line of the traffic capture file
+type CaptureRecord struct {
+	Time   time.Time `json:"time"`
+	ID     uint64    `json:"id"`
+	Remote string    `json:"remote"`
+	Dir    string    `json:"dir"`  // in: data read from the remote, out: data sent to the remote
+	Data   string    `json:"data"` // hex string
+}
+
+// Bytes returns the decoded data of the record
+func (r *CaptureRecord) Bytes() []byte {
+	b, _ := hex.DecodeString(r.Data)
+	return b
+}
+
+// captureFlushDelay 抓包数据写入缓存后，最迟刷新到文件的时间
+const captureFlushDelay = time.Second
+
+// recorder 连接数据抓包
+type recorder struct {
+	locker  sync.Mutex
+	fd      *os.File
+	buf     *bufio.Writer
+	flusher *time.Timer // 延迟刷新缓存
+	logg    logger.Logger
+	name    string
+	size    int64
+	maxSize int64
+	backups int
+	id      uint64
+	remote  string
+}
+
+// newRecorder creates the capture file of a connection, returns nil if capture is disabled or failed
+func newRecorder(dir string, filter func(string) bool, maxSize int64, backups int, id uint64, remote string, logg logger.Logger) *recorder {
+	if dir == "" || (filter != nil && !filter(remote)) {
+		return nil
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		logg.Warning(fmt.Sprintf("[%s] create capture dir failed: %s", remote, err.Error()))
+		return nil
+	}
+	name := strings.NewReplacer(":", "_", "[", "", "]", "", "%", "_").Replace(remote)
+	name = filepath.Join(dir, fmt.Sprintf("%s_%d_%s.jsonl", name, id, time.Now().Format("20060102150405")))
+	fd, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		logg.Warning(fmt.Sprintf("[%s] create capture file failed: %s", remote, err.Error()))
+		return nil
+	}
+	return &recorder{
+		fd:      fd,
+		buf:     bufio.NewWriterSize(fd, 32*1024),
+		logg:    logg,
+		name:    name,
+		maxSize: maxSize,
+		backups: backups,
+		id:      id,
+		remote:  remote,
+	}
+}
+
+func (r *recorder) write(dir string, d []byte) {
+	if r == nil {
+		return
+	}
+	b, err := json.Marshal(&CaptureRecord{
+		Time:   time.Now(),
+		ID:     r.id,
+		Remote: r.remote,
+		Dir:    dir,
+		Data:   hex.EncodeToString(d),
+	})
+	if err != nil {
+		return
+	}
+	r.locker.Lock()
+	defer r.locker.Unlock()
+	if r.fd == nil {
+		return
+	}
+	if r.maxSize > 0 && r.size > 0 && r.size+int64(len(b))+1 > r.maxSize {
+		if !r.rotate() {
+			return
+		}
+	}
+	r.buf.Write(b)
+	r.buf.WriteByte('\n')
+	r.size += int64(len(b)) + 1
+	if r.flusher == nil {
+		r.flusher = time.AfterFunc(captureFlushDelay, r.flush)
+	}
+}
+
+// flush writes the buffered records to the file
+func (r *recorder) flush() {
+	r.locker.Lock()
+	defer r.locker.Unlock()
+	r.flusher = nil
+	if r.fd != nil {
+		r.buf.Flush()
+	}
+}
+
+// backupName returns the name of the nth backup file
+func (r *recorder) backupName(n int) string {
+	if n == 0 {
+		return r.name
+	}
+	return fmt.Sprintf("%s.%d.jsonl", strings.TrimSuffix(r.name, ".jsonl"), n)
+}
+
+// rotate moves the full capture file to the backups and reopens an empty one, the caller must hold the lock
+func (r *recorder) rotate() bool {
+	r.buf.Flush()
+	r.fd.Close()
+	if r.backups == 0 {
+		os.Remove(r.name)
+	}
+	for i := r.backups; i > 0; i-- {
+		os.Rename(r.backupName(i-1), r.backupName(i))
+	}
+	fd, err := os.OpenFile(r.name, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		r.logg.Warning(fmt.Sprintf("[%s] rotate capture file failed: %s", r.remote, err.Error()))
+		r.fd = nil
+		return false
+	}
+	r.fd = fd
+	r.buf.Reset(fd)
+	r.size = 0
+	return true
+}
+
+func (r *recorder) close() {
+	if r == nil {
+		return
+	}
+	r.locker.Lock()
+	defer r.locker.Unlock()
+	if r.flusher != nil {
+		r.flusher.Stop()
+		r.flusher = nil
+	}
+	if r.fd != nil {
+		r.buf.Flush()
+		r.fd.Close()
+		r.fd = nil
+	}
+}
+
+// ReadCapture reads all the records from a traffic capture file
+func ReadCapture(r io.Reader) ([]*CaptureRecord, error) {
+	records := make([]*CaptureRecord, 0)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		rec := &CaptureRecord{}
+		if err := json.Unmarshal(line, rec); err != nil {
+			return nil, err
+		}
+		records = append(records, rec)
+	}
+	return records, scanner.Err()
+}
+
+// Replay feeds the inbound data of a traffic capture into the client offline, in the same way as a live
+// connection does, including the frame codec and the unfinished data cache. OnConnect is not called.
+// It is useful to reproduce protocol issues in unit tests without the physical device.
+//
+// Parameters:
+// - r: The traffic capture, see WithTrafficCapture.
+// - client: The client to be tested.
+// - codec: The frame codec used by the live connection, nil if not used.
+//
+// Return:
+// - The messages the client replied in order, they can be compared with the outbound records of the capture.
+// - An error if the capture is invalid or the frame codec failed.
+func Replay(r io.Reader, client Client, codec FrameCodec) ([]*SendMessage, error) {
+	records, err := ReadCapture(r)
+	if err != nil {
+		return nil, err
+	}
+	t := &tcpCore{
+		sendQueue: queue.NewPriorityQueue[*SendMessage](1 << 20),
+		readCache: &bytes.Buffer{},
+		tcpClient: client,
+		codec:     codec,
+		pending:   &pendingRequests{},
+		metrics:   newMetrics(),
+		logg:      &logger.NilLogger{},
+	}
+	replies := make([]*SendMessage, 0)
+	for _, rec := range records {
+		if rec.Dir != captureIn {
+			continue
+		}
+		err = t.handle(rec.Bytes())
+		for t.sendQueue.Len() > 0 {
+			msg, _ := t.sendQueue.GetWithContext(context.Background())
+			replies = append(replies, msg)
+		}
+		if err != nil {
+			return replies, err
+		}
+	}
+	return replies, nil
+}
@@ -0,0 +1,133 @@
+package tcpfactory
+
+import (
+	"bytes"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/xyzj/toolbox/logger"
+)
+
+type ackClient struct {
+	silentClient
+}
+
+func (t *ackClient) OnRecive(b []byte) ([]byte, []*SendMessage) {
+	return nil, []*SendMessage{{Data: append([]byte("ack:"), b...)}}
+}
+
+func TestCaptureReplay(t *testing.T) {
+	dir := t.TempDir()
+	codec := NewDelimiterCodec([]byte("\n"))
+	tm, _ := NewTcpFactory(WithBindAddr("127.0.0.1:6899"),
+		WithLogger(&logger.NilLogger{}),
+		WithTcpClient(&ackClient{}),
+		WithFrameCodec(codec),
+		WithTrafficCapture(dir, nil))
+	go tm.Listen()
+	time.Sleep(time.Millisecond * 200)
+	dev, err := net.Dial("tcp", "127.0.0.1:6899")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"a\nb", "c\n"} {
+		dev.Write([]byte(s))
+		time.Sleep(time.Millisecond * 100)
+	}
+	dev.Close()
+	time.Sleep(time.Millisecond * 100)
+	tm.Shutdown()
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*.jsonl"))
+	if len(files) != 1 {
+		t.Fatalf("expect 1 capture file, got %v", files)
+	}
+	fd, err := os.Open(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fd.Close()
+	records, err := ReadCapture(fd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := make([][]byte, 0)
+	for _, rec := range records {
+		if rec.Dir == captureOut {
+			out = append(out, rec.Bytes())
+		}
+	}
+	if len(records) != 4 || len(out) != 2 {
+		t.Fatalf("unexpected records: %d, out: %d", len(records), len(out))
+	}
+
+	fd.Seek(0, 0)
+	replies, err := Replay(fd, &ackClient{}, codec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(replies) != len(out) {
+		t.Fatalf("expect %d replies, got %d", len(out), len(replies))
+	}
+	for i, msg := range replies {
+		if !bytes.Equal(msg.Data, out[i]) {
+			t.Fatalf("reply %d: expect %q, got %q", i, out[i], msg.Data)
+		}
+	}
+}
+
+func TestCaptureRotation(t *testing.T) {
+	dir := t.TempDir()
+	r := newRecorder(dir, nil, 1024, 2, 1, "127.0.0.1:1234", &logger.NilLogger{})
+	if r == nil {
+		t.Fatal("recorder not created")
+	}
+	// 写入的数据先进入缓存，延迟刷新到文件
+	r.write(captureIn, []byte("hello"))
+	if fi, _ := os.Stat(r.name); fi.Size() != 0 {
+		t.Fatalf("expect buffered write, got %d bytes in file", fi.Size())
+	}
+	time.Sleep(captureFlushDelay + time.Millisecond*200)
+	if fi, _ := os.Stat(r.name); fi.Size() == 0 {
+		t.Fatal("expect buffered records flushed")
+	}
+
+	for i := range 100 {
+		r.write(captureOut, bytes.Repeat([]byte{byte(i)}, 16))
+	}
+	r.close()
+	files, _ := filepath.Glob(filepath.Join(dir, "*.jsonl"))
+	if len(files) != 3 {
+		t.Fatalf("expect the capture file and 2 backups, got %v", files)
+	}
+	// 最新的记录在当前文件，备份文件按顺序保存较早的记录，超出备份数量的最早记录被丢弃
+	last := -1
+	for _, name := range []string{r.backupName(2), r.backupName(1), r.backupName(0)} {
+		fi, err := os.Stat(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fi.Size() > 1024 {
+			t.Fatalf("%s exceeds the max size: %d", name, fi.Size())
+		}
+		fd, _ := os.Open(name)
+		records, err := ReadCapture(fd)
+		fd.Close()
+		if err != nil || len(records) == 0 {
+			t.Fatalf("%s: %d records, %v", name, len(records), err)
+		}
+		for _, rec := range records {
+			if n := int(rec.Bytes()[0]); last >= 0 && n != last+1 {
+				t.Fatalf("%s: records out of order, got %d after %d", name, n, last)
+			} else {
+				last = n
+			}
+		}
+	}
+	if last != 99 {
+		t.Fatalf("expect the last record kept, got %d", last)
+	}
+}
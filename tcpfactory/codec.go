@@ -0,0 +1,267 @@
+package tcpfactory
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"github.com/xyzj/toolbox"
+)
+
+const (
+	defaultMaxFrameSize = 65535
+)
+
+// FrameCodec splits the raw tcp byte stream into complete frames.
+//
+// When a codec is set by WithFrameCodec, the factory buffers the stream and calls
+// Client.OnRecive once for every complete frame, the unfinished data returned by
+// OnRecive is ignored in this mode.
+//
+// A FrameCodec is shared by all connections, so the implementation must be stateless.
+type FrameCodec interface {
+	// Decode returns the complete frames found in data and the unfinished tail that should be
+	// kept until more data arrives. Returning an error closes the connection.
+	Decode(data []byte) (frames [][]byte, unfinished []byte, err error)
+}
+
+// LengthPrefixCodec decodes frames that begin with a length header.
+type LengthPrefixCodec struct {
+	// HeaderSize is the width of the length header in bytes, supports 1, 2, 4 and 8
+	HeaderSize int
+	// BigEndian reports whether the length header is in big endian order
+	BigEndian bool
+	// LengthIncludesHeader reports whether the length value counts the header itself
+	LengthIncludesHeader bool
+	// StripHeader removes the length header from the frame handed to the client
+	StripHeader bool
+	// MaxFrameSize is the max size of one frame, including the header
+	MaxFrameSize int
+}
+
+// NewLengthPrefixCodec creates a length prefixed frame codec with the given header width and byte order.
+// An unsupported header width falls back to 2 bytes.
+func NewLengthPrefixCodec(headerSize int, bigEndian bool) *LengthPrefixCodec {
+	switch headerSize {
+	case 1, 2, 4, 8:
+	default:
+		headerSize = 2
+	}
+	return &LengthPrefixCodec{
+		HeaderSize:   headerSize,
+		BigEndian:    bigEndian,
+		MaxFrameSize: defaultMaxFrameSize,
+	}
+}
+
+func (c *LengthPrefixCodec) frameLength(h []byte) uint64 {
+	var order binary.ByteOrder = binary.LittleEndian
+	if c.BigEndian {
+		order = binary.BigEndian
+	}
+	switch c.HeaderSize {
+	case 1:
+		return uint64(h[0])
+	case 4:
+		return uint64(order.Uint32(h))
+	case 8:
+		return order.Uint64(h)
+	default:
+		return uint64(order.Uint16(h))
+	}
+}
+
+// Decode implements FrameCodec
+func (c *LengthPrefixCodec) Decode(data []byte) ([][]byte, []byte, error) {
+	var frames [][]byte
+	for len(data) >= c.HeaderSize {
+		l := c.frameLength(data[:c.HeaderSize])
+		if !c.LengthIncludesHeader {
+			l += uint64(c.HeaderSize)
+		}
+		if l < uint64(c.HeaderSize) || (c.MaxFrameSize > 0 && l > uint64(c.MaxFrameSize)) {
+			return frames, nil, ErrFrameTooLarge
+		}
+		if uint64(len(data)) < l {
+			break
+		}
+		if c.StripHeader {
+			frames = append(frames, data[c.HeaderSize:l])
+		} else {
+			frames = append(frames, data[:l])
+		}
+		data = data[l:]
+	}
+	return frames, data, nil
+}
+
+// DelimiterCodec decodes frames separated by a delimiter, the delimiter is removed from the frames.
+type DelimiterCodec struct {
+	// Delimiter marks the end of each frame
+	Delimiter []byte
+	// MaxFrameSize is the max size of one frame, excluding the delimiter
+	MaxFrameSize int
+}
+
+// NewDelimiterCodec creates a delimiter based frame codec, such as []byte("\r\n")
+func NewDelimiterCodec(delimiter []byte) *DelimiterCodec {
+	return &DelimiterCodec{
+		Delimiter:    delimiter,
+		MaxFrameSize: defaultMaxFrameSize,
+	}
+}
+
+// Decode implements FrameCodec, empty frames are skipped
+func (c *DelimiterCodec) Decode(data []byte) ([][]byte, []byte, error) {
+	var frames [][]byte
+	if len(c.Delimiter) == 0 {
+		return [][]byte{data}, nil, nil
+	}
+	for {
+		idx := bytes.Index(data, c.Delimiter)
+		if idx < 0 {
+			break
+		}
+		if c.MaxFrameSize > 0 && idx > c.MaxFrameSize {
+			return frames, nil, ErrFrameTooLarge
+		}
+		if idx > 0 {
+			frames = append(frames, data[:idx])
+		}
+		data = data[idx+len(c.Delimiter):]
+	}
+	if c.MaxFrameSize > 0 && len(data) > c.MaxFrameSize {
+		return frames, nil, ErrFrameTooLarge
+	}
+	return frames, data, nil
+}
+
+// FixedLengthCodec decodes frames of a fixed size
+type FixedLengthCodec struct {
+	// Size is the size of every frame
+	Size int
+}
+
+// NewFixedLengthCodec creates a fixed length frame codec, size is clamped to a minimum of 1
+func NewFixedLengthCodec(size int) *FixedLengthCodec {
+	return &FixedLengthCodec{Size: max(size, 1)}
+}
+
+// Decode implements FrameCodec
+func (c *FixedLengthCodec) Decode(data []byte) ([][]byte, []byte, error) {
+	var frames [][]byte
+	for len(data) >= c.Size {
+		frames = append(frames, data[:c.Size])
+		data = data[c.Size:]
+	}
+	return frames, data, nil
+}
+
+// Checksum defines the checksum placed before the end byte of a StartEndCodec frame
+type Checksum byte
+
+const (
+	// ChecksumNone does not verify the frame
+	ChecksumNone Checksum = iota
+	// ChecksumLrc is a 1 byte xor checksum, see toolbox.CountLrc
+	ChecksumLrc
+	// ChecksumCrc16 is a 2 bytes crc16 in little endian order, see toolbox.CountCrc16VB
+	ChecksumCrc16
+	// ChecksumCrc16BigOrder is a 2 bytes crc16 in big endian order, see toolbox.CountCrc16VB
+	ChecksumCrc16BigOrder
+)
+
+func (c Checksum) size() int {
+	switch c {
+	case ChecksumLrc:
+		return 1
+	case ChecksumCrc16, ChecksumCrc16BigOrder:
+		return 2
+	default:
+		return 0
+	}
+}
+
+func (c Checksum) check(d []byte) bool {
+	switch c {
+	case ChecksumLrc:
+		return toolbox.CheckLrc(d)
+	case ChecksumCrc16:
+		return toolbox.CheckCrc16VB(d)
+	case ChecksumCrc16BigOrder:
+		return toolbox.CheckCrc16VBBigOrder(d)
+	default:
+		return true
+	}
+}
+
+// StartEndCodec decodes frames like: start byte, payload, checksum, end byte.
+//
+// Data before the start byte is discarded, a frame that can not be verified within
+// MaxFrameSize bytes is dropped and the decoder resyncs at the next start byte.
+type StartEndCodec struct {
+	// Start is the first byte of a frame
+	Start byte
+	// End is the last byte of a frame
+	End byte
+	// Checksum is the checksum type placed before the end byte
+	Checksum Checksum
+	// ChecksumOffset is the number of leading bytes excluded from the checksum, such as 1 to skip the start byte
+	ChecksumOffset int
+	// MinFrameSize is the min size of one frame, including start and end bytes
+	MinFrameSize int
+	// MaxFrameSize is the max size of one frame, including start and end bytes
+	MaxFrameSize int
+}
+
+// NewStartEndCodec creates a start/end byte frame codec with the given checksum
+func NewStartEndCodec(start, end byte, checksum Checksum) *StartEndCodec {
+	return &StartEndCodec{
+		Start:        start,
+		End:          end,
+		Checksum:     checksum,
+		MinFrameSize: 2 + checksum.size(),
+		MaxFrameSize: defaultMaxFrameSize,
+	}
+}
+
+// Decode implements FrameCodec
+func (c *StartEndCodec) Decode(data []byte) ([][]byte, []byte, error) {
+	var frames [][]byte
+	minSize := max(c.MinFrameSize, 2+c.Checksum.size()+c.ChecksumOffset)
+	for {
+		idx := bytes.IndexByte(data, c.Start)
+		if idx < 0 {
+			return frames, nil, nil
+		}
+		data = data[idx:]
+		l := c.match(data, minSize)
+		if l > 0 {
+			frames = append(frames, data[:l])
+			data = data[l:]
+			continue
+		}
+		if c.MaxFrameSize > 0 && len(data) >= c.MaxFrameSize {
+			// 找不到合法的帧，丢弃起始字节，重新同步
+			data = data[1:]
+			continue
+		}
+		return frames, data, nil
+	}
+}
+
+// match returns the length of the first verified frame at the beginning of data, or 0 if not found
+func (c *StartEndCodec) match(data []byte, minSize int) int {
+	limit := len(data)
+	if c.MaxFrameSize > 0 {
+		limit = min(limit, c.MaxFrameSize)
+	}
+	for i := minSize - 1; i < limit; i++ {
+		if data[i] != c.End {
+			continue
+		}
+		if c.Checksum == ChecksumNone || c.Checksum.check(data[c.ChecksumOffset:i]) {
+			return i + 1
+		}
+	}
+	return 0
+}
@@ -0,0 +1,144 @@
+package tcpfactory
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/xyzj/toolbox"
+	"github.com/xyzj/toolbox/logger"
+)
+
+// framesReceived 会话收到的数据帧，客户端实例会被复制，所以使用包级变量
+var framesReceived = make(chan []byte, 16)
+
+type frameClient struct {
+	silentClient
+}
+
+func (t *frameClient) OnRecive(b []byte) ([]byte, []*SendMessage) {
+	framesReceived <- append([]byte(nil), b...)
+	return nil, []*SendMessage{{Data: append([]byte("ack:"), b...)}}
+}
+
+func TestLengthPrefixCodec(t *testing.T) {
+	c := NewLengthPrefixCodec(2, true)
+	data := []byte{0x00, 0x03, 'a', 'b', 'c', 0x00, 0x02, 'd'}
+	frames, unfinish, err := c.Decode(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(frames) != 1 || !bytes.Equal(frames[0], []byte{0x00, 0x03, 'a', 'b', 'c'}) {
+		t.Fatalf("unexpected frames: %v", frames)
+	}
+	if !bytes.Equal(unfinish, []byte{0x00, 0x02, 'd'}) {
+		t.Fatalf("unexpected unfinished data: %v", unfinish)
+	}
+
+	c.StripHeader = true
+	c.MaxFrameSize = 4
+	if _, _, err = c.Decode([]byte{0x00, 0x03, 'a'}); err != ErrFrameTooLarge {
+		t.Fatalf("expect ErrFrameTooLarge, got %v", err)
+	}
+}
+
+func TestDelimiterCodec(t *testing.T) {
+	c := NewDelimiterCodec([]byte("\r\n"))
+	frames, unfinish, err := c.Decode([]byte("abc\r\n\r\ndef\r\ngh"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(frames) != 2 || string(frames[0]) != "abc" || string(frames[1]) != "def" {
+		t.Fatalf("unexpected frames: %q", frames)
+	}
+	if string(unfinish) != "gh" {
+		t.Fatalf("unexpected unfinished data: %q", unfinish)
+	}
+}
+
+func TestFixedLengthCodec(t *testing.T) {
+	frames, unfinish, _ := NewFixedLengthCodec(3).Decode([]byte("abcdefg"))
+	if len(frames) != 2 || string(unfinish) != "g" {
+		t.Fatalf("unexpected result: %q %q", frames, unfinish)
+	}
+}
+
+func TestStartEndCodec(t *testing.T) {
+	body := []byte{0x68, 0x01, 0x16, 0x02}
+	frame := append(body, toolbox.CountCrc16VB(&body)...)
+	frame = append(frame, 0x16)
+
+	c := NewStartEndCodec(0x68, 0x16, ChecksumCrc16)
+	// 帧前的无效数据应被丢弃，帧内出现的结束字节不应截断帧
+	data := append([]byte{0xff, 0xee}, frame...)
+	data = append(data, frame[:3]...)
+	frames, unfinish, err := c.Decode(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(frames) != 1 || !bytes.Equal(frames[0], frame) {
+		t.Fatalf("unexpected frames: %v", frames)
+	}
+	if !bytes.Equal(unfinish, frame[:3]) {
+		t.Fatalf("unexpected unfinished data: %v", unfinish)
+	}
+
+	// 校验失败的数据超过最大帧长后重新同步
+	c.MaxFrameSize = 8
+	bad := []byte{0x68, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x16}
+	frames, _, _ = c.Decode(append(bad, frame...))
+	if len(frames) != 1 || !bytes.Equal(frames[0], frame) {
+		t.Fatalf("unexpected frames after resync: %v", frames)
+	}
+}
+
+func TestFrameCodecConnection(t *testing.T) {
+	tm, _ := NewTcpFactory(WithBindAddr("127.0.0.1:6969"),
+		WithLogger(&logger.NilLogger{}),
+		WithTcpClient(&frameClient{}),
+		WithFrameCodec(NewDelimiterCodec([]byte("\r\n"))))
+	go tm.Listen()
+	defer tm.Shutdown()
+	time.Sleep(time.Millisecond * 200)
+	dev, err := net.Dial("tcp", "127.0.0.1:6969")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dev.Close()
+	// 一帧分多次到达，一次到达多帧，分隔符也可能被拆开
+	for _, s := range []string{"ab", "c\r", "\nde\r\nf", "g\r\n"} {
+		dev.Write([]byte(s))
+		time.Sleep(time.Millisecond * 50)
+	}
+	for _, want := range []string{"abc", "de", "fg"} {
+		select {
+		case b := <-framesReceived:
+			if string(b) != want {
+				t.Fatalf("unexpected frame: got=%q want=%q", b, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("frame %q not received", want)
+		}
+	}
+	select {
+	case b := <-framesReceived:
+		t.Fatalf("unexpected extra frame: %q", b)
+	default:
+	}
+
+	want := "ack:abcack:deack:fg"
+	got := make([]byte, 0, len(want))
+	b := make([]byte, 64)
+	dev.SetReadDeadline(time.Now().Add(time.Second))
+	for len(got) < len(want) {
+		n, err := dev.Read(b)
+		if err != nil {
+			t.Fatalf("read replies failed: %v, got %q", err, got)
+		}
+		got = append(got, b[:n]...)
+	}
+	if string(got) != want {
+		t.Fatalf("unexpected replies: %q", got)
+	}
+}
@@ -4,6 +4,7 @@ package tcpfactory
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
 	"encoding/hex"
 	"fmt"
 	"io"
@@ -21,16 +22,27 @@ const (
 )
 
 type tcpCore struct {
-	conn               *net.TCPConn                       // 连接实例
+	conn               net.Conn                           // 连接实例，tcp或tls
 	sendQueue          *queue.PriorityQueue[*SendMessage] // 发送队列
 	closeOnce          *sync.Once                         // 关闭事件
 	readCache          *bytes.Buffer                      // 数据读取临时缓存
 	writeIntervalTimer *time.Timer                        // 发送间隔计时
 	tcpClient          Client                             // 设备功能模块
+	codec              FrameCodec                         // 数据分帧
+	pending            *pendingRequests                   // 等待响应的请求
+	counter            connCounter                        // 连接数据统计
+	metrics            *metrics                           // 全局数据统计
+	recorder           *recorder                          // 数据抓包
+	captureDir         string                             // 抓包文件目录
+	captureFilter      func(string) bool                  // 抓包连接过滤
+	captureMaxSize     int64                              // 抓包文件大小上限
+	captureBackups     int                                // 抓包文件备份数量
+	onIdentity         func(*tcpCore, string)             // 身份登记
+	identity           string                             // 设备身份
 	logg               logger.Logger                      // 日志记录
 	timeConnection     time.Time                          // 连接时间
-	timeLastWrite      time.Time                          // 上次发送时间
-	timeLastRead       time.Time                          // 上次数据读取时间
+	timeLastWrite      atomic.Int64                       // 上次发送时间，unix纳秒，统计协程会并发读取
+	timeLastRead       atomic.Int64                       // 上次数据读取时间，unix纳秒，统计协程会并发读取
 	sendQueueTimeout   time.Duration                      // 发送队列获取超时
 	readTimeout        time.Duration                      // 读取超时
 	writeTimeout       time.Duration                      // 发送超时
@@ -40,27 +52,91 @@ type tcpCore struct {
 	sockID             uint64                             // 实例id
 	remoteAddr         string                             // 远端地址
 	closed             atomic.Bool                        // 是否已关闭
+	unsent             atomic.Int64                       // 已入队但未发送完成的消息数，包括正在发送和等待发送间隔的消息
+}
+
+// lastRead returns the time of the last read
+func (t *tcpCore) lastRead() time.Time {
+	return time.Unix(0, t.timeLastRead.Load())
+}
+
+// lastWrite returns the time of the last write
+func (t *tcpCore) lastWrite() time.Time {
+	return time.Unix(0, t.timeLastWrite.Load())
 }
 
 func (t *tcpCore) formatLog(s string) string {
 	return fmt.Sprintf(logformater, t.remoteAddr, s)
 }
 
-func (t *tcpCore) connect(conn *net.TCPConn, msgs ...*SendMessage) {
+func (t *tcpCore) connect(conn net.Conn, msgs ...*SendMessage) {
 	t.conn = conn
 	t.closed.Store(false)
 	t.closeCtx, t.closeFunc = context.WithCancel(context.Background())
 	t.closeOnce = new(sync.Once)
 	t.remoteAddr = conn.RemoteAddr().String()
 	t.timeConnection = time.Now()
-	t.timeLastRead = t.timeConnection
-	t.timeLastWrite = t.timeConnection
+	t.timeLastRead.Store(t.timeConnection.UnixNano())
+	t.timeLastWrite.Store(t.timeConnection.UnixNano())
+	t.identity = ""
+	t.unsent.Store(0)
 	t.sendQueue.Open()
+	t.counter.reset()
+	t.metrics.accept()
+	t.recorder = newRecorder(t.captureDir, t.captureFilter, t.captureMaxSize, t.captureBackups, t.sockID, t.remoteAddr, t.logg)
 	t.logg.Info(t.formatLog("new connection established with id:" + fmt.Sprintf("%d", t.sockID)))
-	t.tcpClient.OnConnect(conn)
+	switch c := conn.(type) {
+	case *tls.Conn:
+		t.tcpClient.OnConnect(c.NetConn().(*net.TCPConn))
+		if cli, ok := t.tcpClient.(TLSClient); ok {
+			cli.OnTLSHandshake(c.ConnectionState())
+		}
+	case *udpConn:
+		// ListenUDP 已确保 Client 实现了 UDPClient
+		if cli, ok := t.tcpClient.(UDPClient); ok {
+			cli.OnUDPConnect(c.addr)
+		}
+	case *net.TCPConn:
+		t.tcpClient.OnConnect(c)
+	}
 	for _, msg := range msgs {
-		t.sendQueue.Put(queue.PriorityLow, msg)
+		t.enqueue(queue.PriorityLow, msg)
+	}
+}
+
+// enqueue puts the message into the send queue, counts the dropped message if the queue is full
+func (t *tcpCore) enqueue(priority queue.Priority, msg *SendMessage) bool {
+	return t.put(priority, msg) == nil
+}
+
+// put is enqueue returning the error of the send queue
+func (t *tcpCore) put(priority queue.Priority, msg *SendMessage) error {
+	// 先计数再入队，保证消息被取出前已经计入
+	t.unsent.Add(1)
+	err := t.sendQueue.Put(priority, msg)
+	if err != nil {
+		t.unsent.Add(-1)
+	}
+	if err == queue.ErrFull {
+		t.counter.dropped.Add(1)
+		t.metrics.dropped.Add(1)
 	}
+	return err
+}
+
+// sent counts and captures the data sent
+func (t *tcpCore) sent(d []byte) {
+	t.counter.bytesSent.Add(uint64(len(d)))
+	t.counter.framesSent.Add(1)
+	t.metrics.bytesSent.Add(uint64(len(d)))
+	t.metrics.framesSent.Add(1)
+	t.recorder.write(captureOut, d)
+}
+
+// received counts the frames received
+func (t *tcpCore) received() {
+	t.counter.framesRecv.Add(1)
+	t.metrics.framesRecv.Add(1)
 }
 
 func (t *tcpCore) disconnect(s string) {
@@ -68,9 +144,15 @@ func (t *tcpCore) disconnect(s string) {
 		t.closed.Store(true)
 		t.closeFunc()
 		t.conn.Close()
+		// 关闭队列会丢弃其中的消息，同时扣除未发送计数
+		discarded := t.sendQueue.Len()
 		t.sendQueue.Close()
+		t.unsent.Add(-int64(discarded))
 		t.readCache.Reset()
 		t.writeIntervalTimer.Stop()
+		t.pending.clear()
+		t.recorder.close()
+		t.metrics.disconnect(s)
 		t.logg.Debug(t.formatLog("close:" + s))
 		t.tcpClient.OnDisconnect(s)
 	})
@@ -79,8 +161,7 @@ func (t *tcpCore) disconnect(s string) {
 func (t *tcpCore) recv() {
 	var err error
 	var n int
-	var d, unfinish []byte
-	var echo []*SendMessage
+	var d []byte
 	for !t.closed.Load() {
 		if err = t.conn.SetReadDeadline(time.Now().Add(t.readTimeout)); err != nil { // time.Duration(tcpReadTimeout)
 			t.disconnect("set read timeout error: " + err.Error())
@@ -98,28 +179,84 @@ func (t *tcpCore) recv() {
 		if n == 0 {
 			continue
 		}
-		t.timeLastRead = time.Now()
+		t.timeLastRead.Store(time.Now().UnixNano())
+		t.counter.bytesRecv.Add(uint64(n))
+		t.metrics.recv(n)
 		d = t.readBuffer[:n]
 		t.logg.Debug(t.formatLog("read:" + hex.EncodeToString(d)))
-		// 检查缓存
-		if t.readCache.Len() > 0 {
-			t.readCache.Write(d)
-			d = t.readCache.Bytes()
-		}
-		// 清理缓存
-		t.readCache.Reset()
-		// 数据解析
-		unfinish, echo = t.tcpClient.OnRecive(d)
-		if len(unfinish) > 0 {
-			t.readCache.Write(unfinish)
-			t.logg.Debug(t.formatLog("read unfinish:" + hex.EncodeToString(unfinish)))
+		t.recorder.write(captureIn, d)
+		if err = t.handle(d); err != nil {
+			t.disconnect("frame decode error: " + err.Error())
+			return
 		}
-		if len(echo) > 0 {
-			for _, s := range echo {
-				t.sendQueue.Put(queue.PriorityHighest, s)
-			}
+	}
+}
+
+// handle parses the received data and hands it to the client, returns the frame decode error
+func (t *tcpCore) handle(d []byte) error {
+	// 检查缓存
+	if t.readCache.Len() > 0 {
+		t.readCache.Write(d)
+		d = t.readCache.Bytes()
+	}
+	// 清理缓存
+	t.readCache.Reset()
+	// 数据解析
+	if t.codec != nil {
+		return t.recvFrames(d)
+	}
+	unfinish, echo := t.tcpClient.OnRecive(d)
+	t.received()
+	if len(unfinish) <= len(d) {
+		t.pending.dispatch(d[:len(d)-len(unfinish)])
+	}
+	if len(unfinish) > 0 {
+		t.readCache.Write(unfinish)
+		t.logg.Debug(t.formatLog("read unfinish:" + hex.EncodeToString(unfinish)))
+	}
+	for _, s := range echo {
+		t.enqueue(queue.PriorityHighest, s)
+	}
+	t.identify()
+	return nil
+}
+
+// identify registers the identity of the client once it is available
+func (t *tcpCore) identify() {
+	if t.identity != "" || t.onIdentity == nil {
+		return
+	}
+	cli, ok := t.tcpClient.(IdentityClient)
+	if !ok {
+		return
+	}
+	if id := cli.Identity(); id != "" {
+		t.identity = id
+		t.onIdentity(t, id)
+	}
+}
+
+// recvFrames splits data with the frame codec and hands every complete frame to the client
+func (t *tcpCore) recvFrames(d []byte) error {
+	frames, unfinish, err := t.codec.Decode(d)
+	for _, frame := range frames {
+		_, echo := t.tcpClient.OnRecive(frame)
+		t.received()
+		t.pending.dispatch(frame)
+		for _, s := range echo {
+			t.enqueue(queue.PriorityHighest, s)
 		}
 	}
+	t.identify()
+	if err != nil {
+		return err
+	}
+	// 帧处理完毕后再缓存未完成数据，避免覆盖仍在使用的帧数据
+	if len(unfinish) > 0 {
+		t.readCache.Write(unfinish)
+		t.logg.Debug(t.formatLog("read unfinish:" + hex.EncodeToString(unfinish)))
+	}
+	return nil
 }
 
 func (t *tcpCore) send() {
@@ -130,6 +267,7 @@ func (t *tcpCore) send() {
 	if msg, err = t.sendQueue.GetWithContext(ctx); err != nil {
 		return
 	}
+	defer t.unsent.Add(-1)
 	if t.closed.Load() {
 		return
 	}
@@ -150,7 +288,8 @@ func (t *tcpCore) send() {
 			t.disconnect("send error: " + err.Error())
 			return
 		}
-		t.timeLastWrite = time.Now()
+		t.timeLastWrite.Store(time.Now().UnixNano())
+		t.sent(msg.Data)
 		t.logg.Debug(t.formatLog("send:" + hex.EncodeToString(msg.Data)))
 		t.tcpClient.OnSend(msg.Data)
 	}
@@ -183,7 +322,8 @@ func (t *tcpCore) sendMsg(msg *SendMessage) {
 			t.disconnect("send error: " + err.Error())
 			return
 		}
-		t.timeLastWrite = time.Now()
+		t.timeLastWrite.Store(time.Now().UnixNano())
+		t.sent(msg.Data)
 		t.logg.Debug(t.formatLog("send:" + hex.EncodeToString(msg.Data)))
 		t.tcpClient.OnSend(msg.Data)
 	}
@@ -222,7 +362,8 @@ func (t *tcpCore) sendLoop() {
 					t.disconnect("send error: " + err.Error())
 					return
 				}
-				t.timeLastWrite = time.Now()
+				t.timeLastWrite.Store(time.Now().UnixNano())
+				t.sent(msg.Data)
 				t.logg.Debug(t.formatLog("send:" + hex.EncodeToString(msg.Data)))
 				t.tcpClient.OnSend(msg.Data)
 			}
@@ -242,17 +383,36 @@ func (t *tcpCore) sendLoop() {
 	}
 }
 
-func (t *tcpCore) writeTo(priority queue.Priority, target string, msgs ...*SendMessage) bool {
+// writeTo puts the messages into the send queue if the client matches the target,
+// returns whether the target matched, and the first error of the send queue, such as queue.ErrFull
+func (t *tcpCore) writeTo(priority queue.Priority, target string, msgs ...*SendMessage) (bool, error) {
+	if t.closed.Load() {
+		return false, nil
+	}
+	if !t.tcpClient.MatchTarget(target, false) {
+		return false, nil
+	}
+	var err error
+	for _, msg := range msgs {
+		if e := t.put(priority, msg); e != nil && err == nil {
+			err = e
+		}
+	}
+	return true, err
+}
+
+// writeAll puts the messages into the send queue without target matching, returns false if none was queued
+func (t *tcpCore) writeAll(priority queue.Priority, msgs ...*SendMessage) bool {
 	if t.closed.Load() {
 		return false
 	}
-	if t.tcpClient.MatchTarget(target, false) {
-		for _, msg := range msgs {
-			t.sendQueue.Put(priority, msg)
+	queued := false
+	for _, msg := range msgs {
+		if t.enqueue(priority, msg) {
+			queued = true
 		}
-		return true
 	}
-	return false
+	return queued
 }
 
 func (t *tcpCore) healthReport() (any, bool, bool) {
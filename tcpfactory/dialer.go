@@ -0,0 +1,150 @@
+package tcpfactory
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"math/rand"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/xyzj/toolbox"
+	"github.com/xyzj/toolbox/loopfunc"
+)
+
+// NewTcpDialer creates a new TCPManager instance for outbound connections, use Dial to connect to the remote servers.
+// The connections share the same Client, send queue and health report as the ones accepted by Listen.
+// If WithBindAddr is set, the manager can also Listen for incoming connections.
+//
+// Parameters:
+// - opts: Variadic parameter of type Options, which are optional configuration functions for the TCPManager.
+//
+// Return:
+// - A pointer to the created TCPManager instance.
+func NewTcpDialer(opts ...Options) *TCPManager {
+	opt := defaultOpt
+	opt.bind = ""
+	for _, o := range opts {
+		o(&opt)
+	}
+	t := newManager(&opt)
+	if b, ok := toolbox.ValidateIPPort(opt.bind); ok {
+		t.addr = b
+	}
+	return t
+}
+
+// Dial starts to keep a connection with the remote server at addr in a new goroutine, see DialContext.
+//
+// Parameters:
+// - addr: A string representing the remote address in the format "host:port".
+//
+// Return:
+// - An error if the address is not valid or the manager is shut down, otherwise nil.
+func (t *TCPManager) Dial(addr string) error {
+	return t.DialContext(context.Background(), addr)
+}
+
+// DialContext starts to keep a connection with the remote server at addr in a new goroutine.
+// The host is resolved again on every dial, so the reconnects follow the dns changes.
+// When the link drops or the connection fails, it reconnects with exponential backoff and jitter,
+// see WithReconnectBackoff. The backoff is reset only when the link has stayed up for at least maxWait,
+// so a peer that accepts and closes immediately is not redialed at the minimum wait forever.
+// The hello messages are sent again on every reconnect.
+// The connection is closed and no longer reconnected after ctx is done or Shutdown.
+//
+// Parameters:
+// - ctx: The context to stop the connection.
+// - addr: A string representing the remote address in the format "host:port".
+//
+// Return:
+// - An error if the address is not valid or the manager is shut down, otherwise nil.
+func (t *TCPManager) DialContext(ctx context.Context, addr string) error {
+	if t.shutdown.Load() {
+		return fmt.Errorf("tcp manager is shut down")
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if n, e := strconv.Atoi(port); err != nil || e != nil || host == "" || n <= 0 || n > 65535 {
+		return fmt.Errorf("invalid remote address: %s", addr)
+	}
+	go loopfunc.LoopFunc(func(params ...any) {
+		attempt := 0
+		for !t.shutdown.Load() && ctx.Err() == nil {
+			conn, err := t.dial(ctx, addr)
+			if err != nil {
+				wait := backoff(attempt, t.opt.reconnectMin, t.opt.reconnectMax)
+				attempt++
+				t.opt.logg.Error(fmt.Sprintf("[tcp] dial to %s failed: %s, retry in %s", addr, err.Error(), wait.String()))
+				if !t.sleep(ctx, wait) {
+					return
+				}
+				continue
+			}
+			start := time.Now()
+			stop := context.AfterFunc(ctx, func() { conn.Close() })
+			t.serve(conn)
+			stop()
+			// 连接保持足够长时间才重置退避，避免对端接受后立即断开时以最短间隔反复重连
+			if time.Since(start) >= t.opt.reconnectMax {
+				attempt = 0
+			}
+			wait := backoff(attempt, t.opt.reconnectMin, t.opt.reconnectMax)
+			attempt++
+			if !t.sleep(ctx, wait) {
+				return
+			}
+		}
+	}, "tcpdialer "+addr, t.opt.logg.DefaultWriter())
+	return nil
+}
+
+// dial connects to the remote server and performs the tls handshake if WithTLSConfig is set
+func (t *TCPManager) dial(ctx context.Context, addr string) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(ctx, max(t.opt.writeTimeout, time.Second*10))
+	defer cancel()
+	stop := context.AfterFunc(t.closeCtx, cancel)
+	defer stop()
+	d := &net.Dialer{}
+	c, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if t.opt.tlsConf == nil {
+		return c, nil
+	}
+	conf := t.opt.tlsConf.Clone()
+	if conf.ServerName == "" {
+		conf.ServerName, _, _ = net.SplitHostPort(addr)
+	}
+	tlsConn := tls.Client(c, conf)
+	if err = tlsConn.HandshakeContext(ctx); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return tlsConn, nil
+}
+
+// sleep waits for the given duration, returns false if ctx is done or the manager is shut down
+func (t *TCPManager) sleep(ctx context.Context, d time.Duration) bool {
+	tm := time.NewTimer(d)
+	defer tm.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.closeCtx.Done():
+		return false
+	case <-tm.C:
+		return true
+	}
+}
+
+// backoff returns the wait time of the given attempt, doubles from minWait until maxWait,
+// and keeps a random half of it as jitter
+func backoff(attempt int, minWait, maxWait time.Duration) time.Duration {
+	d := minWait << min(attempt, 30)
+	if d <= 0 || d > maxWait {
+		d = maxWait
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
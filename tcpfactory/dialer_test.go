@@ -0,0 +1,106 @@
+package tcpfactory
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/xyzj/toolbox/logger"
+)
+
+func TestBackoff(t *testing.T) {
+	minWait, maxWait := time.Millisecond*100, time.Millisecond*1000
+	for attempt, want := range []time.Duration{100, 200, 400, 800, 1000, 1000} {
+		want *= time.Millisecond
+		for range 20 {
+			if d := backoff(attempt, minWait, maxWait); d < want/2 || d > want {
+				t.Fatalf("attempt %d: wait %v out of [%v, %v]", attempt, d, want/2, want)
+			}
+		}
+	}
+	if d := backoff(100, minWait, maxWait); d > maxWait {
+		t.Fatalf("wait should be capped at %v, got %v", maxWait, d)
+	}
+}
+
+// acceptAll accepts connections and reports them, closes them immediately if hangup is true
+func acceptAll(t *testing.T, hangup bool) (net.Listener, chan net.Conn) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	accepted := make(chan net.Conn, 100)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			if hangup {
+				conn.Close()
+			}
+			accepted <- conn
+		}
+	}()
+	return ln, accepted
+}
+
+func TestDialReconnect(t *testing.T) {
+	ln, accepted := acceptAll(t, true)
+	defer ln.Close()
+	tm := NewTcpDialer(WithLogger(&logger.NilLogger{}),
+		WithTcpClient(&silentClient{}),
+		WithReconnectBackoff(time.Millisecond*100, time.Millisecond*400))
+	defer tm.Shutdown()
+	if err := tm.Dial(ln.Addr().String()); err != nil {
+		t.Fatal(err)
+	}
+
+	// 对端每次接受后立即断开，重连间隔应逐步增加到上限，而不是一直使用最短间隔
+	time.Sleep(time.Millisecond * 1200)
+	n := len(accepted)
+	if n < 3 {
+		t.Fatalf("expect the dialer to reconnect after the peer closes, got %d connections", n)
+	}
+	if n > 9 {
+		t.Fatalf("expect the backoff to grow for short-lived links, got %d connections", n)
+	}
+}
+
+func TestDialContextCancel(t *testing.T) {
+	ln, accepted := acceptAll(t, false)
+	defer ln.Close()
+	tm := NewTcpDialer(WithLogger(&logger.NilLogger{}),
+		WithTcpClient(&silentClient{}),
+		WithReconnectBackoff(time.Millisecond*100, time.Millisecond*400))
+	defer tm.Shutdown()
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := tm.DialContext(ctx, ln.Addr().String()); err != nil {
+		t.Fatal(err)
+	}
+	var conn net.Conn
+	select {
+	case conn = <-accepted:
+		defer conn.Close()
+	case <-time.After(time.Second):
+		t.Fatal("not connected")
+	}
+
+	cancel()
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Fatal("expect the link closed after cancel")
+	} else if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		t.Fatal("link not closed after cancel")
+	}
+	select {
+	case <-accepted:
+		t.Fatal("should not reconnect after cancel")
+	case <-time.After(time.Millisecond * 600):
+	}
+
+	if err := tm.Dial("no-port"); err == nil {
+		t.Fatal("expect invalid address error")
+	}
+}
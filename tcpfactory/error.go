@@ -5,4 +5,16 @@ import "errors"
 var (
 	ErrHostNotValid = errors.New("host not valid")
 	ErrPortNotValid = errors.New("port not valid")
+
+	// ErrFrameTooLarge is returned by a FrameCodec when a frame exceeds the configured maximum size.
+	ErrFrameTooLarge = errors.New("frame too large")
+
+	// ErrTargetNotFound is returned when no connection matches the target.
+	ErrTargetNotFound = errors.New("target not found")
+	// ErrConnectionClosed is returned when the connection is closed before the operation completes.
+	ErrConnectionClosed = errors.New("connection closed")
+	// ErrInvalidRequest is returned when the request message or matcher is nil.
+	ErrInvalidRequest = errors.New("invalid request")
+	// ErrUDPClientRequired is returned by ListenUDP when the Client does not implement UDPClient.
+	ErrUDPClientRequired = errors.New("udp sessions require a client implementing UDPClient")
 )
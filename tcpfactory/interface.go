@@ -1,6 +1,7 @@
 package tcpfactory
 
 import (
+	"crypto/tls"
 	"net"
 	"strings"
 	"time"
@@ -8,6 +9,14 @@ import (
 
 const (
 	strShutMeDown = "shut me down"
+
+	// ReasonServerShutdown is the reason passed to Client.OnDisconnect when the manager shuts down
+	ReasonServerShutdown = "server shutdown"
+	// ReasonServerDrain is the reason passed to Client.OnDisconnect when the manager shuts down gracefully
+	// by ShutdownWithContext
+	ReasonServerDrain = "server drain"
+	// ReasonSessionReplaced is the reason passed to Client.OnDisconnect when a new session logs in with the same identity
+	ReasonSessionReplaced = "session replaced"
 )
 
 var shutmedown = &SendMessage{
@@ -46,7 +55,10 @@ type Client interface {
 	MatchTarget(target string, prefix bool) bool
 	// Report is used to report client status, return status data and if the client is registered, and if the client is shutting down
 	Report() (data any, legal bool, shutdown bool)
-	// OnConnect is called when the connection is established
+	// OnConnect is called when the connection is established, it is not called for udp sessions, see UDPClient.
+	// For tls connections conn is the raw tcp socket under the tls session, it must only be used for
+	// the addresses and socket options, reading or writing it bypasses tls and corrupts the tls stream,
+	// the data should always be sent by the manager, such as WriteTo.
 	OnConnect(conn *net.TCPConn)
 	// OnDisconnect is called when the connection is closed
 	OnDisconnect(reason string)
@@ -56,6 +68,38 @@ type Client interface {
 	OnSend(data []byte)
 }
 
+// TLSClient is an optional interface for the Client working with WithTLSConfig.
+// OnTLSHandshake is called right after OnConnect with the state of the completed tls handshake,
+// the client can use PeerSubject to get the identity of the peer for MatchTarget.
+// Note that the conn passed to OnConnect is the raw tcp socket under tls, never read or write it directly.
+type TLSClient interface {
+	OnTLSHandshake(state tls.ConnectionState)
+}
+
+// UDPClient is the interface the Client must implement to work with ListenUDP.
+// OnUDPConnect is called instead of OnConnect when a new udp session is created,
+// ListenUDP returns ErrUDPClientRequired if the Client does not implement it.
+type UDPClient interface {
+	OnUDPConnect(addr *net.UDPAddr)
+}
+
+// IdentityClient is an optional interface for the Client to enable session migration.
+// Identity is called after each OnRecive until it returns a non-empty string, usually once the device has logged in.
+// When another active session already holds the same identity, the old session is closed with ReasonSessionReplaced
+// and its undelivered messages are moved to the new session, see WithDuplicateLogin.
+type IdentityClient interface {
+	Identity() string
+}
+
+// PeerSubject returns the subject of the verified peer certificate,
+// or an empty string if the peer did not provide a certificate.
+func PeerSubject(state tls.ConnectionState) string {
+	if len(state.PeerCertificates) == 0 {
+		return ""
+	}
+	return state.PeerCertificates[0].Subject.String()
+}
+
 // EmptyClient is a no-op implementation of the Client interface that provides default empty method implementations.
 type EmptyClient struct{}
 
@@ -75,6 +119,9 @@ type EchoClient struct {
 // OnConnect is called when the connection is established, save the remote address as the client name.
 func (t *EchoClient) OnConnect(n *net.TCPConn) { t.name = n.RemoteAddr().String() }
 func (t *EchoClient) OnDisconnect(string)      {}
+
+// OnUDPConnect is called when the udp session is created, save the remote address as the client name.
+func (t *EchoClient) OnUDPConnect(addr *net.UDPAddr) { t.name = addr.String() }
 func (t *EchoClient) MatchTarget(s string, prefix bool) bool {
 	if prefix {
 		return strings.HasPrefix(t.name, s)
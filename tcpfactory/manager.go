@@ -2,10 +2,13 @@ package tcpfactory
 
 import (
 	"bytes"
+	"context"
+	"crypto/tls"
 	"fmt"
 	"math/rand"
 	"net"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -19,11 +22,25 @@ import (
 type TCPManager struct {
 	members     *members //*mapfx.StructMap[uint64, tcpCore]
 	opt         *opt
-	listener    *net.TCPListener
+	listener    atomic.Pointer[net.TCPListener] // 关闭时会被其他协程读取
+	udpListener atomic.Pointer[net.UDPConn]
 	addr        *net.TCPAddr
 	recycle     *gopool.GoPool[*tcpCore]
 	reportCache *reportData
 	shutdown    atomic.Bool
+	closeCtx    context.Context
+	closeFunc   context.CancelFunc
+	metrics     *metrics
+	admission   *admission
+	admitOnce   sync.Once
+	admitErr    error
+	draining    atomic.Bool
+}
+
+// ShutdownSummary is the result of ShutdownWithContext
+type ShutdownSummary struct {
+	Connections int // number of connections closed
+	Discarded   int // number of messages discarded from the send queues, including the ones being written or waiting out their Interval
 }
 
 // HealthReport generates a health report for all members.
@@ -82,10 +99,45 @@ func (t *TCPManager) WriteToMultiTargets(msg *SendMessage, targets ...string) {
 	}
 }
 
+// WriteWhere sends the given messages to the registered connections whose report data matches the predicate.
+// The report data is returned by Client.Report at the time of the call, so a newly registered connection is
+// selected without waiting for its first health check. Report may be called concurrently with the health check.
+//
+// Parameters:
+// - where: A function that reports whether the connection with the given report data should receive the messages.
+// - msgs: Variadic parameter of type *SendMessage, representing the messages to be sent.
+//
+// Return:
+// - The number of connections the messages were queued to.
+func (t *TCPManager) WriteWhere(where func(report any) bool, msgs ...*SendMessage) int {
+	if where == nil || len(msgs) == 0 {
+		return 0
+	}
+	n := 0
+	t.members.ForEach(func(cli *tcpCore) bool {
+		if x, ok, _ := cli.healthReport(); ok && where(x) && cli.writeAll(queue.PriorityNormal, msgs...) {
+			n++
+		}
+		return true
+	})
+	return n
+}
+
+// Broadcast sends the given messages to all registered connections, see WriteWhere.
+//
+// Parameters:
+// - msgs: Variadic parameter of type *SendMessage, representing the messages to be sent.
+//
+// Return:
+// - The number of connections the messages were queued to.
+func (t *TCPManager) Broadcast(msgs ...*SendMessage) int {
+	return t.WriteWhere(func(any) bool { return true }, msgs...)
+}
+
 // Listen starts listening for incoming TCP connections on the specified address.
 // It creates a TCP listener, logs the listening address, and handles incoming connections.
-// For each accepted connection, it creates a new tcpCore instance, sets up keep-alive, linger options,
-// and starts separate goroutines for receiving and sending data.
+// For each accepted connection, it performs the tls handshake if WithTLSConfig is set, creates a new tcpCore
+// instance, sets up keep-alive, linger options, and starts separate goroutines for receiving and sending data.
 // If any error occurs during the listening process, it logs the error and returns the error.
 //
 // Parameters:
@@ -94,104 +146,228 @@ func (t *TCPManager) WriteToMultiTargets(msg *SendMessage, targets ...string) {
 // Return:
 // - An error if any, otherwise nil.
 func (t *TCPManager) Listen() error {
+	if t.addr == nil {
+		return ErrHostNotValid
+	}
+	adm, err := t.loadAdmission()
+	if err != nil {
+		return err
+	}
 	listener, err := net.ListenTCP("tcp", t.addr)
 	if err != nil {
 		t.opt.logg.Error(err.Error())
 		return err
 	}
 	t.opt.logg.System(fmt.Sprintf("[tcp] listening to: %s", listener.Addr().String()))
-	t.listener = listener
+	t.listener.Store(listener)
 	loopfunc.LoopFunc(func(params ...any) {
 		for !t.shutdown.Load() {
-			conn, err := t.listener.AcceptTCP()
+			conn, err := listener.AcceptTCP()
 			if err != nil {
 				t.opt.logg.Error(err.Error())
 				continue
 			}
+			ip := conn.RemoteAddr().(*net.TCPAddr).IP
+			if ok, reason := adm.admit(ip); !ok {
+				t.reject(conn.RemoteAddr().String(), reason)
+				conn.SetLinger(0)
+				conn.Close()
+				continue
+			}
 			go func(conn *net.TCPConn) {
-				cli := t.recycle.Get()
-				if t.opt.keepAlive > 0 {
-					conn.SetKeepAliveConfig(net.KeepAliveConfig{
-						Enable:   true,
-						Idle:     t.opt.keepAlive,
-						Interval: t.opt.keepAlive,
-					})
-				} else {
-					conn.SetKeepAlive(false)
+				defer adm.release(ip)
+				c, err := t.handshake(conn)
+				if err != nil {
+					t.opt.logg.Warning(fmt.Sprintf("[tcp] %s tls handshake failed: %s", conn.RemoteAddr().String(), err.Error()))
+					conn.Close()
+					return
 				}
-				conn.SetLinger(0)
-				t.members.Store(cli.sockID, cli)
-				defer func() {
-					if err := recover(); err != nil {
-						cli.disconnect(fmt.Sprintf("%+v", err))
-					} else {
-						cli.disconnect("socket closed")
-					}
-					if !t.shutdown.Load() {
-						t.members.Delete(cli.sockID)
-						t.reportCache.Delete(cli.sockID)
-						t.recycle.Put(cli)
-					}
-				}()
-				cli.connect(conn, t.opt.helloMsg...) // conn
-				// checkhealth
-				go func() {
-					freport := func() {
-						x, ok, shutdown := cli.healthReport()
-						if shutdown {
-							cli.disconnect("client said shutdown")
-							return
-						}
-						if time.Since(cli.timeLastRead) > t.opt.readTimeout+time.Second*20 {
-							cli.disconnect("socket anomaly")
-							return
-						}
-						if !ok && t.opt.registTimeout > 0 && time.Since(cli.timeConnection) > t.opt.registTimeout {
-							cli.disconnect("unregistered connection")
-							return
-						}
-						if ok {
-							t.reportCache.Store(cli.sockID, &reportItem{
-								id:       cli.sockID,
-								msg:      x,
-								status:   ok,
-								dtReport: time.Now(),
-							})
-						}
-					}
-					defer func() {
-						if err := recover(); err != nil {
-							cli.disconnect(fmt.Sprintf("send panic, %+v", err))
-						}
-					}()
-					t1i := time.Second * time.Duration(rand.Int31n(10)+20)
-					t1 := time.NewTimer(t1i)
-					for !cli.closed.Load() {
-						select {
-						case <-cli.closeCtx.Done():
-							return
-						case <-t1.C:
-							freport()
-							t1.Reset(t1i)
-						default:
-							cli.send()
-						}
-					}
-				}()
-				// recv
-				cli.recv()
+				t.serve(c)
 			}(conn)
 		}
 	}, "tcplistener", t.opt.logg.DefaultWriter())
 	t.opt.logg.System("Shutting down")
-	t.members.ShutdownAll()
+	if !t.draining.Load() {
+		t.members.ShutdownAll(ReasonServerShutdown)
+	}
 	return nil
 }
 
+// serve runs the read, send and health report loops of a connection, blocks until the connection is closed
+func (t *TCPManager) serve(conn net.Conn) {
+	cli := t.recycle.Get()
+	var tcpConn *net.TCPConn
+	switch c := conn.(type) {
+	case *net.TCPConn:
+		tcpConn = c
+	case *tls.Conn:
+		tcpConn = c.NetConn().(*net.TCPConn)
+	}
+	if tcpConn != nil {
+		if t.opt.keepAlive > 0 {
+			tcpConn.SetKeepAliveConfig(net.KeepAliveConfig{
+				Enable:   true,
+				Idle:     t.opt.keepAlive,
+				Interval: t.opt.keepAlive,
+			})
+		} else {
+			tcpConn.SetKeepAlive(false)
+		}
+		tcpConn.SetLinger(0)
+	}
+	// 发送协程退出后才能回收，避免回收后的实例被上一个连接的发送协程修改
+	var sending chan struct{}
+	defer func() {
+		if err := recover(); err != nil {
+			cli.disconnect(fmt.Sprintf("%+v", err))
+		} else {
+			cli.disconnect("socket closed")
+		}
+		if sending != nil {
+			<-sending
+		}
+		if !t.shutdown.Load() {
+			t.members.Delete(cli.sockID)
+			t.reportCache.Delete(cli.sockID)
+			t.recycle.Put(cli)
+		}
+	}()
+	// 连接初始化完成后再登记，避免其他协程读取到初始化中的会话
+	cli.connect(conn, t.opt.helloMsg...) // conn
+	t.members.Store(cli.sockID, cli)
+	// checkhealth
+	sending = make(chan struct{})
+	go func() {
+		defer close(sending)
+		freport := func() {
+			x, ok, shutdown := cli.healthReport()
+			if shutdown {
+				cli.disconnect("client said shutdown")
+				return
+			}
+			if time.Since(cli.lastRead()) > t.opt.readTimeout+time.Second*20 {
+				cli.disconnect("socket anomaly")
+				return
+			}
+			if !ok && t.opt.registTimeout > 0 && time.Since(cli.timeConnection) > t.opt.registTimeout {
+				cli.disconnect("unregistered connection")
+				return
+			}
+			if ok {
+				t.reportCache.Store(cli.sockID, &reportItem{
+					id:       cli.sockID,
+					msg:      x,
+					status:   ok,
+					dtReport: time.Now(),
+				})
+			}
+		}
+		defer func() {
+			if err := recover(); err != nil {
+				cli.disconnect(fmt.Sprintf("send panic, %+v", err))
+			}
+		}()
+		t1i := time.Second * time.Duration(rand.Int31n(10)+20)
+		t1 := time.NewTimer(t1i)
+		for !cli.closed.Load() {
+			select {
+			case <-cli.closeCtx.Done():
+				return
+			case <-t1.C:
+				freport()
+				t1.Reset(t1i)
+			default:
+				cli.send()
+			}
+		}
+	}()
+	// recv
+	cli.recv()
+}
+
+// loadAdmission creates the admission control once, it is shared by Listen and ListenUDP
+func (t *TCPManager) loadAdmission() (*admission, error) {
+	t.admitOnce.Do(func() {
+		t.admission, t.admitErr = newAdmission(t.opt)
+	})
+	return t.admission, t.admitErr
+}
+
+// reject logs and counts a rejected inbound connection
+func (t *TCPManager) reject(addr, reason string) {
+	t.metrics.reject(reason)
+	t.opt.logg.Warning(fmt.Sprintf("[tcp] %s rejected: %s", addr, reason))
+}
+
+// handshake performs the tls handshake when WithTLSConfig is set, returns conn itself if tls is not enabled
+func (t *TCPManager) handshake(conn *net.TCPConn) (net.Conn, error) {
+	if t.opt.tlsConf == nil {
+		return conn, nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), max(t.opt.writeTimeout, time.Second*10))
+	defer cancel()
+	tlsConn := tls.Server(conn, t.opt.tlsConf)
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		return nil, err
+	}
+	return tlsConn, nil
+}
+
 // Shutdown gracefully shuts down the TCPManager.
 func (t *TCPManager) Shutdown() error {
 	t.shutdown.Store(true)
-	return t.listener.Close()
+	t.closeFunc()
+	var err error
+	if l := t.udpListener.Load(); l != nil {
+		err = l.Close()
+	}
+	l := t.listener.Load()
+	if l == nil {
+		t.members.ShutdownAll(ReasonServerShutdown)
+		return err
+	}
+	return l.Close()
+}
+
+// ShutdownWithContext gracefully shuts down the TCPManager.
+// It stops accepting new connections and reconnecting, then waits for every connection to flush its send queue
+// until the queues are empty or ctx is done. A message being written or waiting out its Interval is not yet flushed.
+// All connections are closed afterwards, and Client.OnDisconnect receives ReasonServerDrain.
+//
+// Parameters:
+// - ctx: Bounds the time to wait for the send queues to be flushed.
+//
+// Return:
+// - A summary of the closed connections and the discarded messages.
+// - The error of ctx if the send queues were not flushed in time, otherwise nil.
+func (t *TCPManager) ShutdownWithContext(ctx context.Context) (*ShutdownSummary, error) {
+	t.draining.Store(true)
+	t.shutdown.Store(true)
+	t.closeFunc()
+	if l := t.listener.Load(); l != nil {
+		l.Close()
+	}
+	if l := t.udpListener.Load(); l != nil {
+		l.Close()
+	}
+	var err error
+	tk := time.NewTicker(time.Millisecond * 20)
+	defer tk.Stop()
+DRAIN:
+	for t.members.Pending() > 0 {
+		select {
+		case <-ctx.Done():
+			err = ctx.Err()
+			break DRAIN
+		case <-tk.C:
+		}
+	}
+	s := &ShutdownSummary{}
+	s.Connections, s.Discarded = t.members.ShutdownAll(ReasonServerDrain)
+	if s.Discarded > 0 {
+		t.opt.logg.Warning(fmt.Sprintf("[tcp] shutdown discarded %d messages", s.Discarded))
+	}
+	return s, err
 }
 
 // Len returns the number of active members.
@@ -219,34 +395,74 @@ func NewTcpFactory(opts ...Options) (*TCPManager, error) {
 	if !ok {
 		return nil, fmt.Errorf("invalid bind address: %s", opt.bind)
 	}
+	t := newManager(&opt)
+	t.addr = b
+	if _, err := t.loadAdmission(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func newManager(opt *opt) *TCPManager {
 	sid := atomic.Uint64{}
-	t := &TCPManager{
-		addr:        b,
-		opt:         &opt,
+	ctx, cancel := context.WithCancel(context.Background())
+	m := newMetrics()
+	tm := &TCPManager{
+		metrics:     m,
+		opt:         opt,
 		shutdown:    atomic.Bool{},
+		closeCtx:    ctx,
+		closeFunc:   cancel,
 		members:     newMembers(int(opt.predictedClients), opt.multiTargets), // mapfx.NewStructMap[uint64, tcpCore](),
 		reportCache: newReportData(int(opt.predictedClients)),
-		recycle: gopool.New(
-			func() *tcpCore {
-				t1 := time.NewTimer(time.Minute)
-				t1.Stop()
-				socketid := sid.Add(1)
-				return &tcpCore{
-					sockID:             socketid,
-					sendQueue:          queue.NewPriorityQueue[*SendMessage](int(opt.maxQueue)),
-					closed:             atomic.Bool{},
-					readBuffer:         make([]byte, opt.readBufferSize),
-					readCache:          &bytes.Buffer{},
-					readTimeout:        opt.readTimeout,
-					writeTimeout:       opt.writeTimeout,
-					sendQueueTimeout:   time.Second * 30,
-					writeIntervalTimer: t1,
-					tcpClient:          deepcopy.CopyAny(opt.client),
-					logg:               opt.logg,
-				}
-			},
-			gopool.WithMaxIdleSize(uint32(opt.poolSize)),
-		),
 	}
-	return t, nil
+	tm.recycle = gopool.New(
+		func() *tcpCore {
+			t1 := time.NewTimer(time.Minute)
+			t1.Stop()
+			socketid := sid.Add(1)
+			return &tcpCore{
+				sockID:             socketid,
+				sendQueue:          queue.NewPriorityQueue[*SendMessage](int(opt.maxQueue)),
+				closed:             atomic.Bool{},
+				readBuffer:         make([]byte, opt.readBufferSize),
+				readCache:          &bytes.Buffer{},
+				readTimeout:        opt.readTimeout,
+				writeTimeout:       opt.writeTimeout,
+				sendQueueTimeout:   time.Second * 30,
+				writeIntervalTimer: t1,
+				tcpClient:          deepcopy.CopyAny(opt.client),
+				codec:              opt.codec,
+				pending:            &pendingRequests{},
+				metrics:            m,
+				captureDir:         opt.captureDir,
+				captureFilter:      opt.captureFilter,
+				captureMaxSize:     opt.captureMaxSize,
+				captureBackups:     opt.captureBackups,
+				onIdentity:         tm.migrate,
+				logg:               opt.logg,
+			}
+		},
+		gopool.WithMaxIdleSize(uint32(opt.poolSize)),
+	)
+	return tm
+}
+
+// migrate replaces the old session holding the same identity, moves its undelivered messages to the new session
+func (t *TCPManager) migrate(cli *tcpCore, identity string) {
+	old, ok := t.members.BindIdentity(identity, cli)
+	if !ok {
+		return
+	}
+	n, _ := old.sendQueue.MoveTo(cli.sendQueue)
+	// 未发送计数随消息一起转移
+	old.unsent.Add(-int64(n))
+	cli.unsent.Add(int64(n))
+	// 新会话的队列放不下的消息随旧会话一起丢弃
+	discarded := old.sendQueue.Len()
+	old.disconnect(ReasonSessionReplaced)
+	t.opt.logg.Warning(fmt.Sprintf("[%s] duplicate login of %s, session %s replaced, %d messages moved, %d discarded", cli.remoteAddr, identity, old.remoteAddr, n, discarded))
+	if t.opt.duplicateLogin != nil {
+		t.opt.duplicateLogin(identity, old.remoteAddr, cli.remoteAddr)
+	}
 }
@@ -0,0 +1,265 @@
+package tcpfactory
+
+import (
+	"context"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xyzj/toolbox/logger"
+)
+
+// disconnectReasons 断开连接的原因，客户端实例会被复制，所以使用包级变量
+var disconnectReasons = make(chan string, 4)
+
+type reasonClient struct {
+	silentClient
+}
+
+func (t *reasonClient) OnDisconnect(reason string) {
+	disconnectReasons <- reason
+}
+
+func TestShutdownWithContext(t *testing.T) {
+	for _, tt := range []struct {
+		name      string
+		timeout   time.Duration
+		count     int
+		interval  time.Duration
+		discarded bool
+	}{
+		{name: "flushed", timeout: time.Second * 2, count: 5, interval: time.Millisecond * 100},
+		{name: "timeout", timeout: time.Millisecond * 100, count: 5, interval: time.Millisecond * 100, discarded: true},
+		// 队列已空，但最后一条消息还在等待发送间隔
+		{name: "in flight", timeout: time.Millisecond * 100, count: 1, interval: time.Millisecond * 500},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			tm, _ := NewTcpFactory(WithBindAddr("127.0.0.1:6879"),
+				WithLogger(&logger.NilLogger{}),
+				WithTcpClient(&reasonClient{}))
+			go tm.Listen()
+			time.Sleep(time.Millisecond * 200)
+			dev, err := net.Dial("tcp", "127.0.0.1:6879")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer dev.Close()
+			time.Sleep(time.Millisecond * 100)
+			for range tt.count {
+				tm.WriteTo(dev.LocalAddr().String(), &SendMessage{Data: []byte("x"), Interval: tt.interval})
+			}
+			time.Sleep(time.Millisecond * 50)
+
+			ctx, cancel := context.WithTimeout(context.Background(), tt.timeout)
+			defer cancel()
+			s, err := tm.ShutdownWithContext(ctx)
+			if s.Connections != 1 {
+				t.Fatalf("expect 1 connection closed, got %d", s.Connections)
+			}
+			select {
+			case reason := <-disconnectReasons:
+				if reason != ReasonServerDrain {
+					t.Fatalf("unexpected disconnect reason: %s", reason)
+				}
+			case <-time.After(time.Second):
+				t.Fatal("OnDisconnect not called")
+			}
+			if tt.count == 1 {
+				if err == nil || s.Discarded != 1 {
+					t.Fatalf("expect the message in flight waited and discarded, got %d, %v", s.Discarded, err)
+				}
+				return
+			}
+			if tt.discarded {
+				if err == nil || s.Discarded == 0 {
+					t.Fatalf("expect discarded messages, got %d, %v", s.Discarded, err)
+				}
+				return
+			}
+			if err != nil || s.Discarded != 0 {
+				t.Fatalf("expect all messages flushed, got %d, %v", s.Discarded, err)
+			}
+			dev.SetReadDeadline(time.Now().Add(time.Second))
+			b, _ := io.ReadAll(dev)
+			if string(b) != "xxxxx" {
+				t.Fatalf("unexpected data received: %q", b)
+			}
+		})
+	}
+}
+
+// reportClient 上报远端地址
+type reportClient struct {
+	silentClient
+}
+
+func (t *reportClient) Report() (any, bool, bool) { return t.name, true, false }
+
+func TestWriteWhere(t *testing.T) {
+	tm, _ := NewTcpFactory(WithBindAddr("127.0.0.1:6889"),
+		WithLogger(&logger.NilLogger{}),
+		WithTcpClient(&reportClient{}))
+	go tm.Listen()
+	defer tm.Shutdown()
+	time.Sleep(time.Millisecond * 200)
+	devs := make([]net.Conn, 0, 3)
+	for range 3 {
+		dev, err := net.Dial("tcp", "127.0.0.1:6889")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer dev.Close()
+		devs = append(devs, dev)
+	}
+	// 新连接还没有经过健康检查，也应按当前的上报数据选中
+	time.Sleep(time.Millisecond * 100)
+
+	target := devs[1].LocalAddr().String()
+	if n := tm.WriteWhere(func(report any) bool { return report == target }, &SendMessage{Data: []byte("one")}); n != 1 {
+		t.Fatalf("expect 1 connection selected, got %d", n)
+	}
+	if n := tm.Broadcast(&SendMessage{Data: []byte("all")}); n != 3 {
+		t.Fatalf("expect 3 connections selected, got %d", n)
+	}
+	for i, dev := range devs {
+		want := "all"
+		if i == 1 {
+			want = "oneall"
+		}
+		b := make([]byte, 0, 16)
+		dev.SetReadDeadline(time.Now().Add(time.Second))
+		for len(b) < len(want) {
+			buf := make([]byte, 16)
+			n, err := dev.Read(buf)
+			if err != nil {
+				break
+			}
+			b = append(b, buf[:n]...)
+		}
+		if string(b) != want {
+			t.Fatalf("device %d: expect %q, got %q", i, want, b)
+		}
+	}
+}
+
+type loginClient struct {
+	silentClient
+	id string
+}
+
+func (t *loginClient) Identity() string { return t.id }
+func (t *loginClient) MatchTarget(s string, prefix bool) bool {
+	return t.id != "" && t.id == s
+}
+func (t *loginClient) OnRecive(b []byte) ([]byte, []*SendMessage) {
+	if s, ok := strings.CutPrefix(string(b), "login:"); ok {
+		t.id = s
+	}
+	return nil, nil
+}
+
+func TestShutdownAfterReconnect(t *testing.T) {
+	tm, _ := NewTcpFactory(WithBindAddr("127.0.0.1:6979"),
+		WithLogger(&logger.NilLogger{}),
+		WithTcpClient(&silentClient{}),
+		WithMaxClientPoolSize(2))
+	go tm.Listen()
+	time.Sleep(time.Millisecond * 200)
+	// 连接关闭时队列中还有消息，复用的会话不能继承这些未发送计数
+	for range 3 {
+		dev, err := net.Dial("tcp", "127.0.0.1:6979")
+		if err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(time.Millisecond * 100)
+		for range 5 {
+			tm.WriteTo(dev.LocalAddr().String(), &SendMessage{Data: []byte("x"), Interval: time.Second})
+		}
+		dev.Close()
+		time.Sleep(time.Millisecond * 100)
+	}
+	dev, err := net.Dial("tcp", "127.0.0.1:6979")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dev.Close()
+	time.Sleep(time.Millisecond * 100)
+	if n := tm.members.Pending(); n != 0 {
+		t.Fatalf("expect nothing pending, got %d", n)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	start := time.Now()
+	s, err := tm.ShutdownWithContext(ctx)
+	if err != nil || s.Connections != 1 || s.Discarded != 0 {
+		t.Fatalf("unexpected shutdown: %+v %v", s, err)
+	}
+	if d := time.Since(start); d > time.Millisecond*200 {
+		t.Fatalf("expect shutdown without waiting, took %v", d)
+	}
+}
+
+func TestSessionMigration(t *testing.T) {
+	audit := make(chan [3]string, 1)
+	tm, _ := NewTcpFactory(WithBindAddr("127.0.0.1:6909"),
+		WithLogger(&logger.NilLogger{}),
+		WithTcpClient(&loginClient{}),
+		WithDuplicateLogin(func(identity, oldAddr, newAddr string) {
+			audit <- [3]string{identity, oldAddr, newAddr}
+		}))
+	go tm.Listen()
+	defer tm.Shutdown()
+	time.Sleep(time.Millisecond * 200)
+	login := func() net.Conn {
+		dev, err := net.Dial("tcp", "127.0.0.1:6909")
+		if err != nil {
+			t.Fatal(err)
+		}
+		dev.Write([]byte("login:dev1"))
+		time.Sleep(time.Millisecond * 100)
+		return dev
+	}
+	old := login()
+	defer old.Close()
+	for _, s := range []string{"1", "2", "3", "4"} {
+		tm.WriteTo("dev1", &SendMessage{Data: []byte(s), Interval: time.Millisecond * 200})
+	}
+	oldCli, _ := tm.members.Load("dev1")
+	dev := login()
+	defer dev.Close()
+
+	select {
+	case a := <-audit:
+		if a != [3]string{"dev1", old.LocalAddr().String(), dev.LocalAddr().String()} {
+			t.Fatalf("unexpected audit: %v", a)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("duplicate login not reported")
+	}
+	old.SetReadDeadline(time.Now().Add(time.Second))
+	b1, _ := io.ReadAll(old)
+	dev.SetReadDeadline(time.Now().Add(time.Second))
+	b2 := make([]byte, 0, 4)
+	for len(b1)+len(b2) < 4 {
+		buf := make([]byte, 4)
+		n, err := dev.Read(buf)
+		if err != nil {
+			break
+		}
+		b2 = append(b2, buf[:n]...)
+	}
+	if len(b2) == 0 || string(b1)+string(b2) != "1234" {
+		t.Fatalf("expect messages moved to the new session, got %q and %q", b1, b2)
+	}
+	if cli, ok := tm.members.Load("dev1"); !ok || cli.remoteAddr != dev.LocalAddr().String() {
+		t.Fatal("expect dev1 served by the new session")
+	}
+	// 转移的消息只计入新会话
+	time.Sleep(time.Millisecond * 300)
+	if n, p := oldCli.unsent.Load(), tm.members.Pending(); n != 0 || p != 0 {
+		t.Fatalf("expect nothing left unsent, old session %d, pending %d", n, p)
+	}
+}
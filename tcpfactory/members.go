@@ -10,6 +10,7 @@ type members struct {
 	locker       sync.RWMutex
 	data         map[uint64]*tcpCore
 	targets      map[string]uint64
+	identities   map[string]uint64
 	mulitTargets bool
 }
 
@@ -42,19 +43,42 @@ func (m *members) Shutdown(sid uint64, reason string) {
 				}
 			}
 		}
+		m.forget(sid)
 	}
 	m.locker.Unlock()
 }
 
-// ShutdownAll shuts down all members
-func (m *members) ShutdownAll() {
+// ShutdownAll shuts down all members with reason, returns the number of closed members and discarded messages,
+// the discarded messages include the message being written or waiting out its Interval, see Pending
+func (m *members) ShutdownAll(reason string) (int, int) {
 	m.locker.Lock()
 	defer m.locker.Unlock()
+	conns, discarded := 0, 0
 	for _, v := range m.data {
-		v.disconnect("server shutdown")
+		if !v.closed.Load() {
+			conns++
+			discarded += int(v.unsent.Load())
+		}
+		v.disconnect(reason)
 	}
 	m.targets = make(map[string]uint64)
+	m.identities = make(map[string]uint64)
 	m.data = make(map[uint64]*tcpCore)
+	return conns, discarded
+}
+
+// Pending returns the number of messages not yet sent by the active members,
+// including the message being written or waiting out its Interval
+func (m *members) Pending() int {
+	m.locker.RLock()
+	defer m.locker.RUnlock()
+	n := 0
+	for _, v := range m.data {
+		if !v.closed.Load() {
+			n += int(v.unsent.Load())
+		}
+	}
+	return n
 }
 
 // SendTo sends a message to a specific member
@@ -63,11 +87,13 @@ func (m *members) SendTo(priority queue.Priority, target string, msgs ...*SendMe
 	defer m.locker.RUnlock()
 	if sockID, ok := m.targets[target]; ok {
 		if v, ok := m.data[sockID]; ok {
-			return v.writeTo(priority, target, msgs...)
+			if matched, _ := v.writeTo(priority, target, msgs...); matched {
+				return true
+			}
 		}
 	}
 	for _, v := range m.data {
-		if v.writeTo(priority, target, msgs...) {
+		if matched, _ := v.writeTo(priority, target, msgs...); matched {
 			m.targets[target] = v.sockID
 			return true
 		}
@@ -75,6 +101,42 @@ func (m *members) SendTo(priority queue.Priority, target string, msgs ...*SendMe
 	return false
 }
 
+// Get returns the member by socket ID
+func (m *members) Get(sid uint64) (*tcpCore, bool) {
+	m.locker.RLock()
+	defer m.locker.RUnlock()
+	v, ok := m.data[sid]
+	return v, ok
+}
+
+// Load returns the member matching the target
+func (m *members) Load(target string) (*tcpCore, bool) {
+	m.locker.RLock()
+	defer m.locker.RUnlock()
+	if sockID, ok := m.targets[target]; ok {
+		if v, ok := m.data[sockID]; ok && !v.closed.Load() && v.tcpClient.MatchTarget(target, false) {
+			return v, true
+		}
+	}
+	for _, v := range m.data {
+		if !v.closed.Load() && v.tcpClient.MatchTarget(target, false) {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+// ForEach calls the provided function for each member
+func (m *members) ForEach(f func(cli *tcpCore) bool) {
+	m.locker.RLock()
+	defer m.locker.RUnlock()
+	for _, v := range m.data {
+		if !f(v) {
+			break
+		}
+	}
+}
+
 // Delete removes a member by socket ID
 func (m *members) Delete(sid uint64) {
 	m.locker.Lock()
@@ -87,15 +149,48 @@ func (m *members) Delete(sid uint64) {
 			}
 		}
 	}
+	m.forget(sid)
 	m.locker.Unlock()
 }
 
+// forget removes the identity held by the socket ID, the caller must hold the lock
+func (m *members) forget(sid uint64) {
+	for k, id := range m.identities {
+		if id == sid {
+			delete(m.identities, k)
+			return
+		}
+	}
+}
+
+// BindIdentity binds the identity to the member, returns the active member previously holding the identity
+func (m *members) BindIdentity(identity string, cli *tcpCore) (*tcpCore, bool) {
+	m.locker.Lock()
+	defer m.locker.Unlock()
+	sid, ok := m.identities[identity]
+	m.identities[identity] = cli.sockID
+	if !ok || sid == cli.sockID {
+		return nil, false
+	}
+	old, ok := m.data[sid]
+	if !ok || old.closed.Load() {
+		return nil, false
+	}
+	for k, id := range m.targets {
+		if id == sid {
+			delete(m.targets, k)
+		}
+	}
+	return old, true
+}
+
 // NewMembers creates a new members instance
 func newMembers(l int, mulitTargets bool) *members {
 	return &members{
 		locker:       sync.RWMutex{},
 		data:         make(map[uint64]*tcpCore, l),
 		targets:      make(map[string]uint64, l),
+		identities:   make(map[string]uint64, l),
 		mulitTargets: mulitTargets,
 	}
 }
@@ -1,6 +1,7 @@
 package tcpfactory
 
 import (
+	"crypto/tls"
 	"time"
 
 	"github.com/xyzj/toolbox/logger"
@@ -9,6 +10,20 @@ import (
 type opt struct {
 	logg             logger.Logger
 	client           Client
+	codec            FrameCodec
+	tlsConf          *tls.Config
+	reconnectMin     time.Duration
+	reconnectMax     time.Duration
+	allowCIDRs       []string
+	denyCIDRs        []string
+	maxConns         int32
+	maxConnsPerIP    int32
+	acceptRate       int32
+	captureDir       string
+	captureFilter    func(string) bool
+	captureMaxSize   int64
+	captureBackups   int
+	duplicateLogin   func(identity, oldAddr, newAddr string)
 	readTimeout      time.Duration
 	writeTimeout     time.Duration
 	registTimeout    time.Duration
@@ -20,6 +35,7 @@ type opt struct {
 	poolSize         int32
 	predictedClients int32
 	multiTargets     bool
+	connMetrics      bool
 }
 type Options func(opt *opt)
 
@@ -37,6 +53,8 @@ var defaultOpt = opt{
 	maxQueue:         10,
 	poolSize:         30,
 	multiTargets:     false,
+	reconnectMin:     time.Second,
+	reconnectMax:     time.Minute,
 }
 
 func WithBindAddr(s string) Options {
@@ -243,3 +261,128 @@ func WithMaxClientPoolSize(t int32) Options {
 		o.poolSize = max(2, t)
 	}
 }
+
+// WithFrameCodec returns an option that sets the codec used to split the received byte stream into frames.
+// When set, Client.OnRecive is called once for every complete frame, see FrameCodec.
+//
+// Example usage:
+//
+//	factory, _ := NewTcpFactory(
+//		WithFrameCodec(NewLengthPrefixCodec(2, true)),
+//	)
+func WithFrameCodec(c FrameCodec) Options {
+	return func(o *opt) {
+		o.codec = c
+	}
+}
+
+// WithTLSConfig returns an option that enables tls on the listener, accepted connections are
+// wrapped with the given config and the handshake must complete within the write timeout (at least 10 seconds).
+// To verify client certificates (mutual tls), set ClientAuth and ClientCAs in the config,
+// crypto.TLSConfigFromFile does this when a root file is provided.
+// The client receives the raw tcp socket in OnConnect and the handshake state in TLSClient.OnTLSHandshake,
+// the data must be sent through the manager, writing the raw socket corrupts the tls stream.
+//
+// Example usage:
+//
+//	tc, _ := crypto.TLSConfigFromFile("server.crt", "server.key", "ca.crt")
+//	factory, _ := NewTcpFactory(
+//		WithTLSConfig(tc),
+//	)
+func WithTLSConfig(t *tls.Config) Options {
+	return func(o *opt) {
+		o.tlsConf = t
+	}
+}
+
+// WithReconnectBackoff returns an option that sets the reconnect wait time of the outbound connections created by Dial.
+// The wait time starts from minWait and doubles after every failure until maxWait, with random jitter applied.
+// A link dropped within maxWait counts as a failure, the wait time is reset once a link stays up for maxWait.
+// minWait is clamped to a minimum of 100 milliseconds, and maxWait will not be less than minWait.
+func WithReconnectBackoff(minWait, maxWait time.Duration) Options {
+	return func(o *opt) {
+		o.reconnectMin = max(minWait, 100*time.Millisecond)
+		o.reconnectMax = max(maxWait, o.reconnectMin)
+	}
+}
+
+// WithMaxConnections returns an option that sets the max number of concurrent inbound connections,
+// new connections are rejected when the limit is reached. 0 means no limit.
+func WithMaxConnections(n int32) Options {
+	return func(o *opt) {
+		o.maxConns = max(n, 0)
+	}
+}
+
+// WithMaxConnectionsPerIP returns an option that sets the max number of concurrent inbound connections
+// from one source ip. 0 means no limit.
+func WithMaxConnectionsPerIP(n int32) Options {
+	return func(o *opt) {
+		o.maxConnsPerIP = max(n, 0)
+	}
+}
+
+// WithAllowCIDRs returns an option that only accepts inbound connections from the given networks,
+// such as "192.168.0.0/16" or a single ip "10.0.0.1". The deny list takes precedence over the allow list.
+// NewTcpFactory returns an error if any entry is invalid.
+func WithAllowCIDRs(cidrs ...string) Options {
+	return func(o *opt) {
+		o.allowCIDRs = append(o.allowCIDRs, cidrs...)
+	}
+}
+
+// WithDenyCIDRs returns an option that rejects inbound connections from the given networks,
+// such as "192.168.0.0/16" or a single ip "10.0.0.1".
+// NewTcpFactory returns an error if any entry is invalid.
+func WithDenyCIDRs(cidrs ...string) Options {
+	return func(o *opt) {
+		o.denyCIDRs = append(o.denyCIDRs, cidrs...)
+	}
+}
+
+// WithAcceptRateLimit returns an option that limits the number of inbound connections accepted per second,
+// connections beyond the rate are rejected. 0 means no limit.
+func WithAcceptRateLimit(n int32) Options {
+	return func(o *opt) {
+		o.acceptRate = max(n, 0)
+	}
+}
+
+// WithTrafficCapture returns an option that records the inbound and outbound data of the connections
+// into json lines files under dir, one file per connection, see CaptureRecord and Replay.
+// filter selects the connections to record by remote address, nil records all connections.
+func WithTrafficCapture(dir string, filter func(remoteAddr string) bool) Options {
+	return func(o *opt) {
+		o.captureDir = dir
+		o.captureFilter = filter
+	}
+}
+
+// WithTrafficCaptureRotation returns an option that limits the size of the capture files, see WithTrafficCapture.
+// When a capture file reaches maxSize bytes, it is renamed to <name>.1.jsonl, the older backups are shifted to
+// <name>.2.jsonl and so on, and at most backups files are kept. 0 maxSize means no limit.
+func WithTrafficCaptureRotation(maxSize int64, backups int) Options {
+	return func(o *opt) {
+		o.captureMaxSize = max(maxSize, 0)
+		o.captureBackups = max(backups, 0)
+	}
+}
+
+// WithDuplicateLogin returns an option that sets the callback for duplicate logins,
+// it is called after the old session of the identity has been replaced, see IdentityClient.
+// The callback is used for auditing and should not block.
+func WithDuplicateLogin(f func(identity, oldAddr, newAddr string)) Options {
+	return func(o *opt) {
+		o.duplicateLogin = f
+	}
+}
+
+// WithConnectionMetrics returns an option that enables the per-connection series in PrometheusHandler.
+// The series are labeled with the connection id and remote address, so the number of series grows
+// with every new connection, only enable it with a small and stable number of connections.
+// By default only the aggregate metrics are exported, Stats always includes the per-connection statistics.
+func WithConnectionMetrics(enable bool) Options {
+	return func(o *opt) {
+		o.connMetrics = enable
+	}
+}
@@ -0,0 +1,111 @@
+package tcpfactory
+
+import (
+	"context"
+	"sync"
+
+	"github.com/xyzj/toolbox/queue"
+)
+
+// Matcher reports whether the received frame is the response of a request
+type Matcher func(frame []byte) bool
+
+type waiter struct {
+	matcher Matcher
+	ch      chan []byte
+}
+
+// pendingRequests 等待响应的请求列表，按发送顺序匹配
+type pendingRequests struct {
+	locker  sync.Mutex
+	waiters []*waiter
+}
+
+func (p *pendingRequests) add(m Matcher) *waiter {
+	w := &waiter{
+		matcher: m,
+		ch:      make(chan []byte, 1),
+	}
+	p.locker.Lock()
+	p.waiters = append(p.waiters, w)
+	p.locker.Unlock()
+	return w
+}
+
+func (p *pendingRequests) remove(w *waiter) {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+	for i, v := range p.waiters {
+		if v == w {
+			p.waiters = append(p.waiters[:i], p.waiters[i+1:]...)
+			return
+		}
+	}
+}
+
+// dispatch hands the frame to the first waiter accepting it, returns false if no waiter matched
+func (p *pendingRequests) dispatch(frame []byte) bool {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+	if len(p.waiters) == 0 || len(frame) == 0 {
+		return false
+	}
+	for i, w := range p.waiters {
+		if !w.matcher(frame) {
+			continue
+		}
+		w.ch <- append([]byte(nil), frame...)
+		p.waiters = append(p.waiters[:i], p.waiters[i+1:]...)
+		return true
+	}
+	return false
+}
+
+func (p *pendingRequests) clear() {
+	p.locker.Lock()
+	p.waiters = nil
+	p.locker.Unlock()
+}
+
+// Request sends the message to the target connection and waits for the response.
+// Every frame handed to Client.OnRecive is also offered to the pending requests of the connection in sending order,
+// the first request whose matcher accepts the frame gets a copy of it. Multiple requests can be in flight on one connection.
+//
+// Parameters:
+// - ctx: Controls the timeout of this request, use context.WithTimeout to set the per-request timeout.
+// - target: A string representing the target connection identifier.
+// - msg: The message to be sent.
+// - matcher: Reports whether a received frame is the response.
+//
+// Return:
+// - The response frame.
+// - ErrTargetNotFound if no connection matches the target, queue.ErrFull if the send queue of the connection is full,
+// ErrConnectionClosed if the connection is closed before the response arrives, or the error of ctx.
+func (t *TCPManager) Request(ctx context.Context, target string, msg *SendMessage, matcher Matcher) ([]byte, error) {
+	if msg == nil || matcher == nil {
+		return nil, ErrInvalidRequest
+	}
+	cli, ok := t.members.Load(target)
+	if !ok {
+		return nil, ErrTargetNotFound
+	}
+	closed := cli.closeCtx
+	w := cli.pending.add(matcher)
+	defer cli.pending.remove(w)
+	matched, err := cli.writeTo(queue.PriorityNormal, target, msg)
+	if !matched {
+		return nil, ErrTargetNotFound
+	}
+	// 消息未能放入发送队列时不再等待响应
+	if err != nil {
+		return nil, err
+	}
+	select {
+	case b := <-w.ch:
+		return b, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-closed.Done():
+		return nil, ErrConnectionClosed
+	}
+}
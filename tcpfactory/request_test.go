@@ -0,0 +1,102 @@
+package tcpfactory
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/xyzj/toolbox/logger"
+	"github.com/xyzj/toolbox/queue"
+)
+
+type silentClient struct {
+	EchoClient
+}
+
+func (t *silentClient) OnSend([]byte) {}
+func (t *silentClient) OnRecive(b []byte) ([]byte, []*SendMessage) {
+	return nil, nil
+}
+
+func TestRequest(t *testing.T) {
+	tm, _ := NewTcpFactory(WithBindAddr("127.0.0.1:6849"),
+		WithLogger(&logger.NilLogger{}),
+		WithTcpClient(&silentClient{}),
+		WithFrameCodec(NewDelimiterCodec([]byte("\n"))))
+	go tm.Listen()
+	defer tm.Shutdown()
+	time.Sleep(time.Millisecond * 200)
+
+	dev, err := net.Dial("tcp", "127.0.0.1:6849")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dev.Close()
+	// 模拟设备，倒序应答收到的请求
+	go func() {
+		b := make([]byte, 1024)
+		reqs := make([]string, 0)
+		for {
+			n, err := dev.Read(b)
+			if err != nil {
+				return
+			}
+			for _, s := range strings.Split(strings.TrimSpace(string(b[:n])), "\n") {
+				reqs = append(reqs, s)
+			}
+			if len(reqs) == 2 {
+				dev.Write([]byte("ack:" + reqs[1] + "\nack:" + reqs[0] + "\n"))
+			}
+		}
+	}()
+	time.Sleep(time.Millisecond * 200)
+
+	target := dev.LocalAddr().String()
+	wg := sync.WaitGroup{}
+	for _, req := range []string{"a", "b"} {
+		wg.Add(1)
+		go func(req string) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+			defer cancel()
+			resp, err := tm.Request(ctx, target, &SendMessage{Data: []byte(req + "\n")}, func(frame []byte) bool {
+				return bytes.Equal(frame, []byte("ack:"+req))
+			})
+			if err != nil {
+				t.Errorf("request %s failed: %v", req, err)
+				return
+			}
+			if string(resp) != "ack:"+req {
+				t.Errorf("unexpected response of %s: %s", req, resp)
+			}
+		}(req)
+	}
+	wg.Wait()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*200)
+	defer cancel()
+	if _, err = tm.Request(ctx, target, &SendMessage{Data: []byte("c\n")}, func([]byte) bool { return true }); err != context.DeadlineExceeded {
+		t.Fatalf("expect timeout, got %v", err)
+	}
+	if _, err = tm.Request(ctx, "unknown", &SendMessage{Data: []byte("c\n")}, func([]byte) bool { return true }); err != ErrTargetNotFound {
+		t.Fatalf("expect ErrTargetNotFound, got %v", err)
+	}
+
+	// 发送队列已满时立即返回，不等待超时
+	for range 12 {
+		tm.WriteTo(target, &SendMessage{Data: []byte("x\n"), Interval: time.Second})
+	}
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second*2)
+	defer cancel()
+	start := time.Now()
+	if _, err = tm.Request(ctx, target, &SendMessage{Data: []byte("d\n")}, func([]byte) bool { return true }); err != queue.ErrFull {
+		t.Fatalf("expect queue.ErrFull, got %v", err)
+	}
+	if time.Since(start) > time.Millisecond*100 {
+		t.Fatalf("request with full queue should return immediately, took %v", time.Since(start))
+	}
+}
@@ -0,0 +1,270 @@
+package tcpfactory
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	rateWindow      = 60 // 接入速率统计窗口，秒
+	reasonMaxLength = 32
+)
+
+// ConnStats is the statistics of one connection
+type ConnStats struct {
+	ID             uint64    `json:"id"`
+	RemoteAddr     string    `json:"remote_addr"`
+	BytesRecv      uint64    `json:"bytes_recv"`
+	BytesSent      uint64    `json:"bytes_sent"`
+	FramesRecv     uint64    `json:"frames_recv"`
+	FramesSent     uint64    `json:"frames_sent"`
+	Dropped        uint64    `json:"dropped"`
+	QueueLen       int       `json:"queue_len"`
+	TimeConnection time.Time `json:"time_connection"`
+	TimeLastRead   time.Time `json:"time_last_read"`
+	TimeLastWrite  time.Time `json:"time_last_write"`
+}
+
+// Stats is a snapshot of the TCPManager statistics, the totals include the closed connections
+type Stats struct {
+	Connections       int               `json:"connections"`
+	Accepted          uint64            `json:"accepted"`
+	AcceptRate        float64           `json:"accept_rate"` // connections per second in the last minute
+	BytesRecv         uint64            `json:"bytes_recv"`
+	BytesSent         uint64            `json:"bytes_sent"`
+	FramesRecv        uint64            `json:"frames_recv"`
+	FramesSent        uint64            `json:"frames_sent"`
+	Dropped           uint64            `json:"dropped"`
+	DisconnectReasons map[string]uint64 `json:"disconnect_reasons"`
+	RejectReasons     map[string]uint64 `json:"reject_reasons"`
+	Conns             []*ConnStats      `json:"conns"`
+}
+
+// connCounter 连接数据计数
+type connCounter struct {
+	bytesRecv  atomic.Uint64
+	bytesSent  atomic.Uint64
+	framesRecv atomic.Uint64
+	framesSent atomic.Uint64
+	dropped    atomic.Uint64
+}
+
+func (c *connCounter) reset() {
+	c.bytesRecv.Store(0)
+	c.bytesSent.Store(0)
+	c.framesRecv.Store(0)
+	c.framesSent.Store(0)
+	c.dropped.Store(0)
+}
+
+// metrics 全局统计，所有连接共享
+type metrics struct {
+	connCounter
+	accepted atomic.Uint64
+	locker   sync.Mutex
+	reasons  map[string]uint64
+	rejects  map[string]uint64
+	buckets  [rateWindow]uint64
+	seconds  [rateWindow]int64
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		reasons: make(map[string]uint64),
+		rejects: make(map[string]uint64),
+	}
+}
+
+func (m *metrics) recv(n int) {
+	m.bytesRecv.Add(uint64(n))
+}
+
+func (m *metrics) accept() {
+	m.accepted.Add(1)
+	now := time.Now().Unix()
+	idx := now % rateWindow
+	m.locker.Lock()
+	if m.seconds[idx] != now {
+		m.seconds[idx] = now
+		m.buckets[idx] = 0
+	}
+	m.buckets[idx]++
+	m.locker.Unlock()
+}
+
+// acceptRate returns the average accepted connections per second in the last minute
+func (m *metrics) acceptRate() float64 {
+	now := time.Now().Unix()
+	var sum uint64
+	m.locker.Lock()
+	for i, sec := range m.seconds {
+		if now-sec < rateWindow {
+			sum += m.buckets[i]
+		}
+	}
+	m.locker.Unlock()
+	return float64(sum) / rateWindow
+}
+
+func (m *metrics) disconnect(reason string) {
+	m.locker.Lock()
+	m.reasons[reasonKey(reason)]++
+	m.locker.Unlock()
+}
+
+func (m *metrics) reject(reason string) {
+	m.locker.Lock()
+	m.rejects[reason]++
+	m.locker.Unlock()
+}
+
+// reasonKey 将断开原因归类，去除错误详情
+func reasonKey(reason string) string {
+	if idx := strings.Index(reason, ":"); idx > 0 {
+		reason = reason[:idx]
+	}
+	reason = strings.TrimSpace(strings.TrimSuffix(reason, "!"))
+	if len(reason) > reasonMaxLength {
+		reason = reason[:reasonMaxLength]
+	}
+	return reason
+}
+
+// Stats returns a snapshot of the statistics of the manager and all the active connections
+func (t *TCPManager) Stats() *Stats {
+	m := t.metrics
+	s := &Stats{
+		Accepted:          m.accepted.Load(),
+		AcceptRate:        m.acceptRate(),
+		BytesRecv:         m.bytesRecv.Load(),
+		BytesSent:         m.bytesSent.Load(),
+		FramesRecv:        m.framesRecv.Load(),
+		FramesSent:        m.framesSent.Load(),
+		Dropped:           m.dropped.Load(),
+		DisconnectReasons: make(map[string]uint64),
+		RejectReasons:     make(map[string]uint64),
+		Conns:             make([]*ConnStats, 0, t.members.Len()),
+	}
+	m.locker.Lock()
+	for k, v := range m.reasons {
+		s.DisconnectReasons[k] = v
+	}
+	for k, v := range m.rejects {
+		s.RejectReasons[k] = v
+	}
+	m.locker.Unlock()
+	t.members.ForEach(func(cli *tcpCore) bool {
+		if cli.closed.Load() {
+			return true
+		}
+		s.Conns = append(s.Conns, cli.stats())
+		return true
+	})
+	s.Connections = len(s.Conns)
+	sort.Slice(s.Conns, func(i, j int) bool { return s.Conns[i].ID < s.Conns[j].ID })
+	return s
+}
+
+// PrometheusHandler writes the statistics in the prometheus text format.
+// Only the aggregate metrics are exported unless WithConnectionMetrics is enabled.
+func (t *TCPManager) PrometheusHandler(w http.ResponseWriter, req *http.Request) {
+	s := t.Stats()
+	b := &strings.Builder{}
+	queued := 0
+	for _, c := range s.Conns {
+		queued += c.QueueLen
+	}
+	writeMetric(b, "tcpfactory_connections", "gauge", "Number of active connections.", float64(s.Connections))
+	writeMetric(b, "tcpfactory_send_queue", "gauge", "Messages waiting in the send queues of all connections.", float64(queued))
+	writeMetric(b, "tcpfactory_accepted_total", "counter", "Total number of established connections.", float64(s.Accepted))
+	writeMetric(b, "tcpfactory_accept_rate", "gauge", "Established connections per second in the last minute.", s.AcceptRate)
+	writeMetric(b, "tcpfactory_received_bytes_total", "counter", "Total bytes received.", float64(s.BytesRecv))
+	writeMetric(b, "tcpfactory_sent_bytes_total", "counter", "Total bytes sent.", float64(s.BytesSent))
+	writeMetric(b, "tcpfactory_received_frames_total", "counter", "Total frames received.", float64(s.FramesRecv))
+	writeMetric(b, "tcpfactory_sent_frames_total", "counter", "Total frames sent.", float64(s.FramesSent))
+	writeMetric(b, "tcpfactory_dropped_messages_total", "counter", "Total messages dropped because the send queue is full.", float64(s.Dropped))
+
+	writeReasons(b, "tcpfactory_disconnects_total", "Total disconnections by reason.", s.DisconnectReasons)
+	writeReasons(b, "tcpfactory_rejected_total", "Total rejected inbound connections by reason.", s.RejectReasons)
+
+	if t.opt.connMetrics {
+		writeConnMetrics(b, s.Conns)
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write([]byte(b.String()))
+}
+
+// writeConnMetrics writes the per-connection series, labeled with the connection id and remote address
+func writeConnMetrics(b *strings.Builder, cs []*ConnStats) {
+	now := time.Now()
+	conns := []struct {
+		name, typ, help string
+		value           func(c *ConnStats) float64
+	}{
+		{"tcpfactory_conn_received_bytes_total", "counter", "Bytes received by the connection.", func(c *ConnStats) float64 { return float64(c.BytesRecv) }},
+		{"tcpfactory_conn_sent_bytes_total", "counter", "Bytes sent by the connection.", func(c *ConnStats) float64 { return float64(c.BytesSent) }},
+		{"tcpfactory_conn_received_frames_total", "counter", "Frames received by the connection.", func(c *ConnStats) float64 { return float64(c.FramesRecv) }},
+		{"tcpfactory_conn_sent_frames_total", "counter", "Frames sent by the connection.", func(c *ConnStats) float64 { return float64(c.FramesSent) }},
+		{"tcpfactory_conn_dropped_messages_total", "counter", "Messages dropped by the connection.", func(c *ConnStats) float64 { return float64(c.Dropped) }},
+		{"tcpfactory_conn_send_queue", "gauge", "Messages waiting in the send queue of the connection.", func(c *ConnStats) float64 { return float64(c.QueueLen) }},
+		{"tcpfactory_conn_last_read_seconds", "gauge", "Seconds since the last read of the connection.", func(c *ConnStats) float64 { return now.Sub(c.TimeLastRead).Seconds() }},
+		{"tcpfactory_conn_last_write_seconds", "gauge", "Seconds since the last write of the connection.", func(c *ConnStats) float64 { return now.Sub(c.TimeLastWrite).Seconds() }},
+	}
+	for _, m := range conns {
+		writeHelp(b, m.name, m.typ, m.help)
+		for _, c := range cs {
+			fmt.Fprintf(b, "%s{id=\"%d\",remote=\"%s\"} %s\n", m.name, c.ID, escapeLabel(c.RemoteAddr), formatValue(m.value(c)))
+		}
+	}
+}
+
+func (t *tcpCore) stats() *ConnStats {
+	return &ConnStats{
+		ID:             t.sockID,
+		RemoteAddr:     t.remoteAddr,
+		BytesRecv:      t.counter.bytesRecv.Load(),
+		BytesSent:      t.counter.bytesSent.Load(),
+		FramesRecv:     t.counter.framesRecv.Load(),
+		FramesSent:     t.counter.framesSent.Load(),
+		Dropped:        t.counter.dropped.Load(),
+		QueueLen:       t.sendQueue.Len(),
+		TimeConnection: t.timeConnection,
+		TimeLastRead:   t.lastRead(),
+		TimeLastWrite:  t.lastWrite(),
+	}
+}
+
+func writeHelp(b *strings.Builder, name, typ, help string) {
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+func writeReasons(b *strings.Builder, name, help string, reasons map[string]uint64) {
+	keys := make([]string, 0, len(reasons))
+	for k := range reasons {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	writeHelp(b, name, "counter", help)
+	for _, k := range keys {
+		fmt.Fprintf(b, "%s{reason=\"%s\"} %d\n", name, escapeLabel(k), reasons[k])
+	}
+}
+
+func writeMetric(b *strings.Builder, name, typ, help string, v float64) {
+	writeHelp(b, name, typ, help)
+	fmt.Fprintf(b, "%s %s\n", name, formatValue(v))
+}
+
+func formatValue(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+func escapeLabel(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
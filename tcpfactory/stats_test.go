@@ -0,0 +1,120 @@
+package tcpfactory
+
+import (
+	"bufio"
+	"net"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xyzj/toolbox/logger"
+)
+
+// parseExposition parses the prometheus text format, returns the samples by series and the types by metric name
+func parseExposition(t *testing.T, body string) (map[string]float64, map[string]string) {
+	t.Helper()
+	samples := make(map[string]float64)
+	types := make(map[string]string)
+	helps := make(map[string]bool)
+	sc := bufio.NewScanner(strings.NewReader(body))
+	for sc.Scan() {
+		line := sc.Text()
+		switch {
+		case strings.HasPrefix(line, "# HELP "):
+			helps[strings.Fields(line)[2]] = true
+		case strings.HasPrefix(line, "# TYPE "):
+			f := strings.Fields(line)
+			if len(f) != 4 || (f[3] != "counter" && f[3] != "gauge") {
+				t.Fatalf("invalid type line: %q", line)
+			}
+			types[f[2]] = f[3]
+		case line == "":
+		default:
+			idx := strings.LastIndex(line, " ")
+			if idx <= 0 {
+				t.Fatalf("invalid sample line: %q", line)
+			}
+			series := line[:idx]
+			v, err := strconv.ParseFloat(line[idx+1:], 64)
+			if err != nil {
+				t.Fatalf("invalid sample value: %q", line)
+			}
+			name := series
+			if i := strings.Index(series, "{"); i > 0 {
+				if !strings.HasSuffix(series, "}") {
+					t.Fatalf("invalid labels: %q", line)
+				}
+				name = series[:i]
+			}
+			if _, ok := types[name]; !ok || !helps[name] {
+				t.Fatalf("sample without HELP or TYPE: %q", line)
+			}
+			samples[series] = v
+		}
+	}
+	return samples, types
+}
+
+func TestPrometheusHandler(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		conns bool
+	}{
+		{name: "aggregate"},
+		{name: "per connection", conns: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			tm, _ := NewTcpFactory(WithBindAddr("127.0.0.1:6959"),
+				WithLogger(&logger.NilLogger{}),
+				WithTcpClient(&ackClient{}),
+				WithConnectionMetrics(tt.conns))
+			go tm.Listen()
+			defer tm.Shutdown()
+			time.Sleep(time.Millisecond * 200)
+			dev, err := net.Dial("tcp", "127.0.0.1:6959")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer dev.Close()
+			dev.Write([]byte("ping"))
+			dev.SetReadDeadline(time.Now().Add(time.Second))
+			dev.Read(make([]byte, 16))
+			time.Sleep(time.Millisecond * 100)
+
+			s := tm.Stats()
+			if s.Connections != 1 || s.BytesRecv != 4 || s.BytesSent != 8 || len(s.Conns) != 1 {
+				t.Fatalf("unexpected stats: %+v", s)
+			}
+
+			rec := httptest.NewRecorder()
+			tm.PrometheusHandler(rec, httptest.NewRequest("GET", "/metrics", nil))
+			if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+				t.Fatalf("unexpected content type: %s", ct)
+			}
+			samples, types := parseExposition(t, rec.Body.String())
+			for name, want := range map[string]float64{
+				"tcpfactory_connections":           1,
+				"tcpfactory_accepted_total":        1,
+				"tcpfactory_received_bytes_total":  4,
+				"tcpfactory_sent_bytes_total":      8,
+				"tcpfactory_received_frames_total": 1,
+				"tcpfactory_sent_frames_total":     1,
+			} {
+				if samples[name] != want {
+					t.Fatalf("%s: got %v want %v", name, samples[name], want)
+				}
+			}
+			if types["tcpfactory_connections"] != "gauge" || types["tcpfactory_sent_bytes_total"] != "counter" {
+				t.Fatalf("unexpected metric types: %v", types)
+			}
+
+			series := `tcpfactory_conn_sent_bytes_total{id="` + strconv.FormatUint(s.Conns[0].ID, 10) +
+				`",remote="` + dev.LocalAddr().String() + `"}`
+			if v, ok := samples[series]; ok != tt.conns || (ok && v != 8) {
+				t.Fatalf("per connection series %s: present=%v value=%v", series, ok, v)
+			}
+		})
+	}
+}
@@ -0,0 +1,124 @@
+package tcpfactory
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/xyzj/toolbox/logger"
+)
+
+// tlsSubjects 握手完成后上报的对端证书主题，客户端实例会被复制，所以使用包级变量
+var tlsSubjects = make(chan string, 4)
+
+type tlsClient struct {
+	silentClient
+}
+
+func (t *tlsClient) OnTLSHandshake(state tls.ConnectionState) {
+	tlsSubjects <- PeerSubject(state)
+}
+
+// newTestCert issues a certificate signed by parent, a nil parent means a self-signed ca
+func newTestCert(t *testing.T, cn string, parent *tls.Certificate, usage x509.ExtKeyUsage) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn, Organization: []string{"toolbox"}},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	signer, signerKey := tpl, any(key)
+	if parent == nil {
+		tpl.IsCA = true
+		tpl.BasicConstraintsValid = true
+	} else {
+		signer, signerKey = parent.Leaf, parent.PrivateKey
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tpl, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, _ := x509.ParseCertificate(der)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+func TestTLSHandshake(t *testing.T) {
+	ca := newTestCert(t, "test ca", nil, x509.ExtKeyUsageAny)
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.Leaf)
+	server := newTestCert(t, "server", &ca, x509.ExtKeyUsageServerAuth)
+	device := newTestCert(t, "device-01", &ca, x509.ExtKeyUsageClientAuth)
+
+	tm, _ := NewTcpFactory(WithBindAddr("127.0.0.1:6919"),
+		WithLogger(&logger.NilLogger{}),
+		WithTcpClient(&tlsClient{}),
+		WithTLSConfig(&tls.Config{
+			Certificates: []tls.Certificate{server},
+			ClientAuth:   tls.RequireAndVerifyClientCert,
+			ClientCAs:    pool,
+		}))
+	go tm.Listen()
+	defer tm.Shutdown()
+	time.Sleep(time.Millisecond * 200)
+
+	dial := func(certs ...tls.Certificate) (*tls.Conn, error) {
+		conn, err := tls.Dial("tcp", "127.0.0.1:6919", &tls.Config{RootCAs: pool, Certificates: certs})
+		if err != nil {
+			return nil, err
+		}
+		// tls1.3 的客户端证书校验结果在第一次读取时返回
+		conn.SetReadDeadline(time.Now().Add(time.Millisecond * 300))
+		_, err = conn.Read(make([]byte, 1))
+		if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			err = nil
+		}
+		return conn, err
+	}
+
+	conn, err := dial(device)
+	if err != nil {
+		t.Fatalf("handshake with client certificate failed: %v", err)
+	}
+	defer conn.Close()
+	select {
+	case s := <-tlsSubjects:
+		if s != device.Leaf.Subject.String() {
+			t.Fatalf("unexpected peer subject: %s", s)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("OnTLSHandshake not called")
+	}
+
+	// 加密通道可以正常收发
+	tm.WriteTo(conn.LocalAddr().String(), &SendMessage{Data: []byte("hello")})
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	b := make([]byte, 5)
+	if n, err := conn.Read(b); err != nil || string(b[:n]) != "hello" {
+		t.Fatalf("unexpected data: %q %v", b[:n], err)
+	}
+
+	if conn, err := dial(); err == nil {
+		conn.Close()
+		t.Fatal("handshake without client certificate should fail")
+	}
+	select {
+	case s := <-tlsSubjects:
+		t.Fatalf("OnTLSHandshake should not be called for a rejected peer, got %s", s)
+	case <-time.After(time.Millisecond * 100):
+	}
+}
@@ -0,0 +1,195 @@
+package tcpfactory
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/xyzj/toolbox/loopfunc"
+)
+
+const (
+	udpMaxPacketSize = 65535
+	udpPacketQueue   = 64
+)
+
+// udpConn 虚拟的udp会话连接，每个远端地址对应一个会话
+type udpConn struct {
+	listener  *net.UDPConn
+	addr      *net.UDPAddr
+	packets   chan []byte
+	remain    []byte // 上次读取未取完的数据包
+	closed    chan struct{}
+	closeOnce sync.Once
+	deadline  atomic.Int64
+	onClose   func()
+}
+
+func newUDPConn(listener *net.UDPConn, addr *net.UDPAddr, onClose func()) *udpConn {
+	return &udpConn{
+		listener: listener,
+		addr:     addr,
+		packets:  make(chan []byte, udpPacketQueue),
+		closed:   make(chan struct{}),
+		onClose:  onClose,
+	}
+}
+
+// Read returns the next packet of this session, a packet larger than b is returned by several reads like a tcp stream
+func (c *udpConn) Read(b []byte) (int, error) {
+	if len(c.remain) > 0 {
+		n := copy(b, c.remain)
+		c.remain = c.remain[n:]
+		return n, nil
+	}
+	var timeout <-chan time.Time
+	if d := c.deadline.Load(); d > 0 {
+		tm := time.NewTimer(time.Until(time.Unix(0, d)))
+		defer tm.Stop()
+		timeout = tm.C
+	}
+	select {
+	case p := <-c.packets:
+		n := copy(b, p)
+		c.remain = p[n:]
+		return n, nil
+	case <-c.closed:
+		return 0, io.EOF
+	case <-timeout:
+		return 0, os.ErrDeadlineExceeded
+	}
+}
+
+func (c *udpConn) Write(b []byte) (int, error) {
+	select {
+	case <-c.closed:
+		return 0, net.ErrClosed
+	default:
+	}
+	return c.listener.WriteToUDP(b, c.addr)
+}
+
+func (c *udpConn) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		if c.onClose != nil {
+			c.onClose()
+		}
+	})
+	return nil
+}
+
+func (c *udpConn) LocalAddr() net.Addr  { return c.listener.LocalAddr() }
+func (c *udpConn) RemoteAddr() net.Addr { return c.addr }
+
+func (c *udpConn) SetDeadline(t time.Time) error {
+	return c.SetReadDeadline(t)
+}
+
+func (c *udpConn) SetReadDeadline(t time.Time) error {
+	if t.IsZero() {
+		c.deadline.Store(0)
+	} else {
+		c.deadline.Store(t.UnixNano())
+	}
+	return nil
+}
+
+// SetWriteDeadline does nothing, the udp listener is shared by all sessions
+func (c *udpConn) SetWriteDeadline(time.Time) error { return nil }
+
+// push queues a received packet, returns false if the session is closed or its packet queue is full
+func (c *udpConn) push(p []byte) bool {
+	select {
+	case <-c.closed:
+		return false
+	case c.packets <- p:
+		return true
+	default:
+		return false
+	}
+}
+
+// ListenUDP starts listening for udp packets on the bind address.
+// Each remote address is mapped to a virtual session that works like a tcp connection: it has its own Client
+// instance and send queue, and is reported by HealthReport and targeted by WriteTo.
+// A session expires when no packet is received within the read timeout, see WithReadTimeout.
+// The Client must implement UDPClient, otherwise ErrUDPClientRequired is returned.
+// Packets larger than the read buffer size are handed over in several reads like a tcp stream,
+// use WithFrameCodec or the unfinished data of OnRecive to reassemble them, see WithReadBufferSize.
+//
+// Parameters:
+// - None
+//
+// Return:
+// - An error if any, otherwise nil.
+func (t *TCPManager) ListenUDP() error {
+	if t.addr == nil {
+		return ErrHostNotValid
+	}
+	if _, ok := t.opt.client.(UDPClient); !ok {
+		return ErrUDPClientRequired
+	}
+	adm, err := t.loadAdmission()
+	if err != nil {
+		return err
+	}
+	listener, err := net.ListenUDP("udp", &net.UDPAddr{IP: t.addr.IP, Port: t.addr.Port, Zone: t.addr.Zone})
+	if err != nil {
+		t.opt.logg.Error(err.Error())
+		return err
+	}
+	t.opt.logg.System(fmt.Sprintf("[udp] listening to: %s", listener.LocalAddr().String()))
+	t.udpListener.Store(listener)
+	sessions := make(map[string]*udpConn)
+	locker := sync.Mutex{}
+	loopfunc.LoopFunc(func(params ...any) {
+		buf := make([]byte, udpMaxPacketSize)
+		for !t.shutdown.Load() {
+			n, raddr, err := listener.ReadFromUDP(buf)
+			if err != nil {
+				if !t.shutdown.Load() {
+					t.opt.logg.Error(err.Error())
+				}
+				continue
+			}
+			if n == 0 {
+				continue
+			}
+			key := raddr.String()
+			locker.Lock()
+			sess, ok := sessions[key]
+			if !ok {
+				if ok, reason := adm.admit(raddr.IP); !ok {
+					locker.Unlock()
+					t.reject(key, reason)
+					continue
+				}
+				sess = newUDPConn(listener, raddr, nil)
+				sess.onClose = func() {
+					adm.release(raddr.IP)
+					locker.Lock()
+					if sessions[key] == sess {
+						delete(sessions, key)
+					}
+					locker.Unlock()
+				}
+				sessions[key] = sess
+				go t.serve(sess)
+			}
+			locker.Unlock()
+			if !sess.push(append([]byte(nil), buf[:n]...)) {
+				t.opt.logg.Warning(fmt.Sprintf("[udp] %s packet dropped", key))
+			}
+		}
+	}, "udplistener", t.opt.logg.DefaultWriter())
+	t.opt.logg.System("Shutting down")
+	if !t.draining.Load() {
+		t.members.ShutdownAll(ReasonServerShutdown)
+	}
+	return nil
+}
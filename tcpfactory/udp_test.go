@@ -0,0 +1,120 @@
+package tcpfactory
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/xyzj/toolbox/logger"
+)
+
+// udpReceived 会话收到的数据，客户端实例会被复制，所以使用包级变量
+var udpReceived = make(chan []byte, 16)
+
+type udpRecvClient struct {
+	silentClient
+}
+
+func (t *udpRecvClient) OnRecive(b []byte) ([]byte, []*SendMessage) {
+	udpReceived <- append([]byte(nil), b...)
+	return nil, nil
+}
+
+func countMembers(tm *TCPManager) int {
+	n := 0
+	tm.members.ForEach(func(cli *tcpCore) bool {
+		if !cli.closed.Load() {
+			n++
+		}
+		return true
+	})
+	return n
+}
+
+func TestUDPSessions(t *testing.T) {
+	tm, _ := NewTcpFactory(WithBindAddr("127.0.0.1:6929"),
+		WithLogger(&logger.NilLogger{}),
+		WithTcpClient(&silentClient{}),
+		WithReadTimeout(time.Second))
+	go tm.ListenUDP()
+	defer tm.Shutdown()
+	time.Sleep(time.Millisecond * 200)
+
+	devs := make([]*net.UDPConn, 0, 2)
+	for range 2 {
+		dev, err := net.DialUDP("udp", nil, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 6929})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer dev.Close()
+		// 同一地址的多个数据包属于同一会话
+		dev.Write([]byte("hello"))
+		dev.Write([]byte("again"))
+		devs = append(devs, dev)
+	}
+	time.Sleep(time.Millisecond * 200)
+	if n := countMembers(tm); n != 2 {
+		t.Fatalf("expect one session per remote address, got %d", n)
+	}
+
+	tm.WriteTo(devs[1].LocalAddr().String(), &SendMessage{Data: []byte("only you")})
+	b := make([]byte, 64)
+	devs[1].SetReadDeadline(time.Now().Add(time.Second))
+	if n, err := devs[1].Read(b); err != nil || string(b[:n]) != "only you" {
+		t.Fatalf("unexpected data: %q %v", b[:n], err)
+	}
+	devs[0].SetReadDeadline(time.Now().Add(time.Millisecond * 200))
+	if n, err := devs[0].Read(b); err == nil {
+		t.Fatalf("unexpected data to other session: %q", b[:n])
+	}
+
+	// 超过读取超时没有收到数据，会话过期
+	time.Sleep(time.Millisecond * 1500)
+	if n := countMembers(tm); n != 0 {
+		t.Fatalf("expect idle sessions expired, got %d", n)
+	}
+}
+
+func TestUDPLargeDatagram(t *testing.T) {
+	tm, _ := NewTcpFactory(WithBindAddr("127.0.0.1:6939"),
+		WithLogger(&logger.NilLogger{}),
+		WithTcpClient(&udpRecvClient{}),
+		WithReadBufferSize(1024))
+	go tm.ListenUDP()
+	defer tm.Shutdown()
+	time.Sleep(time.Millisecond * 200)
+
+	dev, err := net.DialUDP("udp", nil, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 6939})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dev.Close()
+	want := bytes.Repeat([]byte("0123456789"), 300)
+	dev.Write(want)
+
+	got := make([]byte, 0, len(want))
+	for len(got) < len(want) {
+		select {
+		case b := <-udpReceived:
+			if len(b) > 1024 {
+				t.Fatalf("read larger than the read buffer: %d", len(b))
+			}
+			got = append(got, b...)
+		case <-time.After(time.Second):
+			t.Fatalf("datagram truncated, got %d of %d bytes", len(got), len(want))
+		}
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatal("datagram corrupted")
+	}
+}
+
+func TestUDPClientRequired(t *testing.T) {
+	tm, _ := NewTcpFactory(WithBindAddr("127.0.0.1:6949"),
+		WithLogger(&logger.NilLogger{}),
+		WithTcpClient(&EmptyClient{}))
+	if err := tm.ListenUDP(); err != ErrUDPClientRequired {
+		t.Fatalf("expect ErrUDPClientRequired, got %v", err)
+	}
+}